@@ -1,6 +1,12 @@
 package cgroups
 
 import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
 	log "github.com/sirupsen/logrus"
 )
@@ -21,24 +27,171 @@ func NewCgroupManager(path string) *CgroupManager {
 }
 
 // Apply adds pid to every cgroup
+// the subsystems the host has not mounted are skipped like in Set, the first one that fails stops the rest
 func (c *CgroupManager) Apply(pid int) error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Apply(c.Path, pid)
+		if subsystems.FindCgroupMountpoint(subSysIns.Name()) == "" {
+			continue
+		}
+		if err := subSysIns.Apply(c.Path, pid); err != nil {
+			return fmt.Errorf("apply cgroup %s of subsystem %s error %v", c.Path, subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
 // Set cgroup resource limits mounted on each subsystem
+// the subsystems the host has not mounted are skipped, the first one that fails stops the rest
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
-		subSysIns.Set(c.Path, res)
+		if subsystems.FindCgroupMountpoint(subSysIns.Name()) == "" {
+			log.Warnf("subsystem %s is not mounted, its limits are ignored", subSysIns.Name())
+			continue
+		}
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			return fmt.Errorf("set cgroup %s of subsystem %s error %v", c.Path, subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
+// Update changes the limits of a live cgroup to res like Set does, but if a subsystem fails,
+// the limits of the subsystems set so far, the failing one included, are restored to what they were
+func (c *CgroupManager) Update(res *subsystems.ResourceConfig) error {
+	var saved []subsystems.Limits
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if subsystems.FindCgroupMountpoint(subSysIns.Name()) == "" {
+			log.Warnf("subsystem %s is not mounted, its limits are ignored", subSysIns.Name())
+			continue
+		}
+		limits, err := subsystems.SaveLimits(subSysIns.Name(), c.Path)
+		if err != nil {
+			c.restore(saved)
+			return fmt.Errorf("save limits of cgroup %s of subsystem %s error %v", c.Path, subSysIns.Name(), err)
+		}
+		saved = append(saved, limits)
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			c.restore(saved)
+			return fmt.Errorf("set cgroup %s of subsystem %s error %v", c.Path, subSysIns.Name(), err)
+		}
+	}
+	return nil
+}
+
+// restore restores the saved limits of the cgroup, the last saved first
+func (c *CgroupManager) restore(saved []subsystems.Limits) {
+	for i := len(saved) - 1; i >= 0; i-- {
+		if err := saved[i].Restore(); err != nil {
+			log.Errorf("restore limits of cgroup %s error %v", c.Path, err)
+		}
+	}
+}
+
+// GetPids returns the pids of the processes in the cgroup, as listed in the hierarchy of the first subsystem
+func (c *CgroupManager) GetPids() ([]int, error) {
+	subsysCgroupPath, err := subsystems.GetCgroupPath(subsystems.SubsystemsIns[0].Name(), c.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	procsURL := path.Join(subsysCgroupPath, "cgroup.procs")
+	content, err := ioutil.ReadFile(procsURL)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s error %v", procsURL, err)
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %s in %s", line, procsURL)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// Freeze stops every process in the cgroup with the freezer subsystem
+func (c *CgroupManager) Freeze() error {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if freezer, ok := subSysIns.(subsystems.Freezer); ok {
+			return freezer.Freeze(c.Path)
+		}
+	}
+	return fmt.Errorf("no freezer subsystem")
+}
+
+// Thaw lets the processes in the cgroup run again after Freeze
+func (c *CgroupManager) Thaw() error {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if freezer, ok := subSysIns.(subsystems.Freezer); ok {
+			return freezer.Thaw(c.Path)
+		}
+	}
+	return fmt.Errorf("no freezer subsystem")
+}
+
+// NotifyOOM returns a channel that receives the OOM events of the cgroup until stop is called or the cgroup is destroyed
+func (c *CgroupManager) NotifyOOM() (<-chan struct{}, func(), error) {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if notifier, ok := subSysIns.(subsystems.OOMNotifier); ok {
+			return notifier.NotifyOOM(c.Path)
+		}
+	}
+	return nil, nil, fmt.Errorf("no OOM notifier subsystem")
+}
+
+// OOMKills returns how many processes of the cgroup the OOM killer has killed so far
+func (c *CgroupManager) OOMKills() (uint64, error) {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if notifier, ok := subSysIns.(subsystems.OOMNotifier); ok {
+			return notifier.OOMKills(c.Path)
+		}
+	}
+	return 0, fmt.Errorf("no OOM notifier subsystem")
+}
+
+// GetStats returns the resource usage of the cgroup, as accounted by every subsystem
+func (c *CgroupManager) GetStats() (*subsystems.Stats, error) {
+	stats := &subsystems.Stats{}
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if subsystems.FindCgroupMountpoint(subSysIns.Name()) == "" {
+			continue
+		}
+		if reader, ok := subSysIns.(subsystems.StatsReader); ok {
+			if err := reader.GetStats(c.Path, stats); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// GetMemoryUsage returns the bytes of memory the cgroup uses, as accounted by the memory subsystem
+func (c *CgroupManager) GetMemoryUsage() (uint64, error) {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if counter, ok := subSysIns.(subsystems.MemoryCounter); ok {
+			return counter.MemoryUsage(c.Path)
+		}
+	}
+	return 0, fmt.Errorf("no memory subsystem")
+}
+
+// GetPidsStats returns the number of processes in the cgroup, as counted by the pids subsystem
+func (c *CgroupManager) GetPidsStats() (*subsystems.PidsStats, error) {
+	for _, subSysIns := range subsystems.SubsystemsIns {
+		if counter, ok := subSysIns.(subsystems.PidsCounter); ok {
+			return counter.PidsStats(c.Path)
+		}
+	}
+	return nil, fmt.Errorf("no pids subsystem")
+}
+
 // Destroy releases cgroups mounted on each subsystem
 func (c *CgroupManager) Destroy() error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
+		// the cgroup may be gone already, as both the waiter of a container and rm destroy it
+		if _, err := subsystems.GetCgroupPath(subSysIns.Name(), c.Path, false); err != nil {
+			continue
+		}
 		if err := subSysIns.Remove(c.Path); err != nil {
 			log.Warnf("remove cgroup fail %v", err)
 		}
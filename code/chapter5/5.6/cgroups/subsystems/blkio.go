@@ -0,0 +1,144 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BlkioSubSystem struct
+// it shares out the block I/O of the host, so that a container busy with the disk does not starve the others
+type BlkioSubSystem struct {
+}
+
+// ThrottleDevice caps the I/O of a cgroup on the block device major:minor at Rate, in bytes or operations per second
+type ThrottleDevice struct {
+	Major int64
+	Minor int64
+	Rate  uint64
+}
+
+// String returns the device and rate the way the throttle files of blkio take them
+func (t ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", t.Major, t.Minor, t.Rate)
+}
+
+// Set will configure the block I/O weight and throttling of the cgroup designated by cgroupPath
+func (b *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, true); err == nil {
+		subsysName, _ := path.Split(subsysCgroupPath)
+		log.Infof("found subsystem's cgroupPath at %s", subsysName)
+		if res.BlkioWeight != "" {
+			// blkio.weight belongs to the CFQ scheduler, kernels with BFQ instead only have blkio.bfq.weight
+			weightURL := path.Join(subsysCgroupPath, "blkio.weight")
+			if _, err := os.Stat(weightURL); err != nil {
+				weightURL = path.Join(subsysCgroupPath, "blkio.bfq.weight")
+			}
+			if _, err := os.Stat(weightURL); err != nil {
+				// neither scheduler is there to weigh the I/O, the throttling below still works
+				log.Warnf("kernel does not support blkio weight, blkio weight %s is ignored", res.BlkioWeight)
+			} else if err := ioutil.WriteFile(weightURL, []byte(res.BlkioWeight), 0644); err != nil {
+				return fmt.Errorf("set cgroup blkio weight fail %v", err)
+			}
+		}
+		throttles := []struct {
+			file    string
+			devices []ThrottleDevice
+		}{
+			{"blkio.throttle.read_bps_device", res.BlkioDeviceReadBps},
+			{"blkio.throttle.write_bps_device", res.BlkioDeviceWriteBps},
+			{"blkio.throttle.read_iops_device", res.BlkioDeviceReadIOps},
+			{"blkio.throttle.write_iops_device", res.BlkioDeviceWriteIOps},
+		}
+		for _, throttle := range throttles {
+			// every write sets the limit of one device
+			for _, device := range throttle.devices {
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, throttle.file), []byte(device.String()), 0644); err != nil {
+					return fmt.Errorf("set cgroup %s %s fail %v", throttle.file, device, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// GetStats reads the bytes the cgroup specified by cgroupPath has read from and written to every block device
+func (b *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	// the throttle files count I/O whatever the scheduler, in lines such as 8:0 Read 4096
+	// older kernels only have the one without the I/O of the child cgroups, which containers do not have anyway
+	for _, file := range []string{"blkio.throttle.io_service_bytes_recursive", "blkio.throttle.io_service_bytes"} {
+		statURL := path.Join(subsysCgroupPath, file)
+		content, err := ioutil.ReadFile(statURL)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read %s error %v", statURL, err)
+		}
+		stats.Blkio = parseBlkioBytes(string(content))
+		return nil
+	}
+	return nil
+}
+
+// parseBlkioBytes sums up the bytes read and written in the lines of a blkio.throttle.io_service_bytes file
+func parseBlkioBytes(content string) *BlkioStats {
+	blkio := &BlkioStats{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			blkio.Read += n
+		case "Write":
+			blkio.Write += n
+		}
+	}
+	return blkio
+}
+
+// Remove removes the cgroup specified by cgroupPath
+func (b *BlkioSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, false); err == nil {
+		// deleting the correspoinding cgroupPath will delete the cgroup
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Apply adds a process to the cgroup specified by cgroupPath
+func (b *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+}
+
+// Name returns cgroup's name
+func (b *BlkioSubSystem) Name() string {
+	return "blkio"
+}
@@ -25,6 +25,17 @@ func (c *CPUSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 				return fmt.Errorf("set cgroup CPU share fail %v", err)
 			}
 		}
+		// the period goes first, the quota is checked against it
+		if res.CPUPeriod != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(res.CPUPeriod), 0644); err != nil {
+				return fmt.Errorf("set cgroup CPU period fail %v", err)
+			}
+		}
+		if res.CPUQuota != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(res.CPUQuota), 0644); err != nil {
+				return fmt.Errorf("set cgroup CPU quota fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
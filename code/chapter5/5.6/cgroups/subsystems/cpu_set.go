@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -20,7 +21,10 @@ func (c *CPUsetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
 		subsysName, _ := path.Split(subsysCgroupPath)
 		log.Infof("found subsystem's cgroupPath at %s", subsysName)
-		if res.CPUShare != "" {
+		if err := c.inheritCpuset(subsysCgroupPath); err != nil {
+			return err
+		}
+		if res.CPUSet != "" {
 			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CPUSet), 0644); err != nil {
 				return fmt.Errorf("set cgroup CPUset fail %v", err)
 			}
@@ -45,6 +49,9 @@ func (c *CPUsetSubSystem) Remove(cgroupPath string) error {
 func (c *CPUsetSubSystem) Apply(cgroupPath string, pid int) error {
 	// GetCgroupPath gets the path of the current subsystem in the virtual fs
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
+		if err := c.inheritCpuset(subsysCgroupPath); err != nil {
+			return err
+		}
 		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
@@ -58,3 +65,34 @@ func (c *CPUsetSubSystem) Apply(cgroupPath string, pid int) error {
 func (c *CPUsetSubSystem) Name() string {
 	return "cpuset"
 }
+
+// inheritCpuset fills in the empty cpuset.cpus and cpuset.mems of the cgroups from the root of the hierarchy down to subsysCgroupPath
+// with the ones of their parents, a new cpuset cgroup starts with both empty and takes no processes until they are set
+func (c *CPUsetSubSystem) inheritCpuset(subsysCgroupPath string) error {
+	parent := FindCgroupMountpoint(c.Name())
+	rel := strings.TrimPrefix(subsysCgroupPath, parent)
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" {
+			continue
+		}
+		dir := path.Join(parent, name)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			content, err := ioutil.ReadFile(path.Join(dir, file))
+			if err != nil {
+				return fmt.Errorf("read %s of cgroup %s fail %v", file, dir, err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			parentContent, err := ioutil.ReadFile(path.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read %s of cgroup %s fail %v", file, parent, err)
+			}
+			if err := ioutil.WriteFile(path.Join(dir, file), parentContent, 0644); err != nil {
+				return fmt.Errorf("set %s of cgroup %s fail %v", file, dir, err)
+			}
+		}
+		parent = dir
+	}
+	return nil
+}
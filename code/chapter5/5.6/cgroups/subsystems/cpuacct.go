@@ -0,0 +1,57 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+)
+
+// CpuacctSubSystem struct
+// it sets no limits, it accounts for the CPU time of a cgroup, which is mounted apart from cpu on some hosts
+type CpuacctSubSystem struct {
+}
+
+// Set does nothing, cpuacct has no limits
+func (c *CpuacctSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+// Remove removes the cgroup specified by cgroupPath
+func (c *CpuacctSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		// deleting the correspoinding cgroupPath will delete the cgroup
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Apply adds a process to the cgroup specified by cgroupPath
+func (c *CpuacctSubSystem) Apply(cgroupPath string, pid int) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+}
+
+// Name returns cgroup's name
+func (c *CpuacctSubSystem) Name() string {
+	return "cpuacct"
+}
+
+// GetStats reads the CPU time the processes of the cgroup specified by cgroupPath have used
+func (c *CpuacctSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	stats.CPUUsage, err = readUint(path.Join(subsysCgroupPath, "cpuacct.usage"))
+	return err
+}
@@ -0,0 +1,84 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreezerSubSystem struct
+type FreezerSubSystem struct {
+}
+
+// Set does nothing, the freezer has no resource limits, it freezes a cgroup on demand with Freeze
+func (f *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	_, err := GetCgroupPath(f.Name(), cgroupPath, true)
+	return err
+}
+
+// Remove removes the cgroup specified by cgroupPath
+func (f *FreezerSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false); err == nil {
+		// deleting the correspoinding cgroupPath will delete the cgroup
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Apply adds a process to the cgroup specified by cgroupPath
+func (f *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+}
+
+// Name returns cgroup's name
+func (f *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// Freeze stops every process in the cgroup specified by cgroupPath until Thaw
+func (f *FreezerSubSystem) Freeze(cgroupPath string) error {
+	return f.setState(cgroupPath, "FROZEN")
+}
+
+// Thaw lets the processes in the cgroup specified by cgroupPath run again
+func (f *FreezerSubSystem) Thaw(cgroupPath string) error {
+	return f.setState(cgroupPath, "THAWED")
+}
+
+// setState writes state to freezer.state and waits for the kernel to get there,
+// freezing goes through FREEZING until every process is stopped
+func (f *FreezerSubSystem) setState(cgroupPath string, state string) error {
+	subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	stateURL := path.Join(subsysCgroupPath, "freezer.state")
+	for i := 0; i < 1000; i++ {
+		// the state is written again, a process forked while freezing can leave the cgroup in FREEZING
+		if err := ioutil.WriteFile(stateURL, []byte(state), 0644); err != nil {
+			return fmt.Errorf("set cgroup freezer state fail %v", err)
+		}
+		current, err := ioutil.ReadFile(stateURL)
+		if err != nil {
+			return fmt.Errorf("read cgroup freezer state fail %v", err)
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("cgroup %s did not get %s", cgroupPath, state)
+}
@@ -0,0 +1,142 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// limitFiles are the files each subsystem writes the limits of a cgroup to
+var limitFiles = map[string][]string{
+	"cpu":    {"cpu.shares", "cpu.cfs_period_us", "cpu.cfs_quota_us"},
+	"cpuset": {"cpuset.cpus"},
+	"memory": {"memory.limit_in_bytes", "memory.memsw.limit_in_bytes", "memory.soft_limit_in_bytes", "memory.oom_control"},
+	"pids":   {"pids.max"},
+	"blkio": {"blkio.weight", "blkio.bfq.weight", "blkio.throttle.read_bps_device", "blkio.throttle.write_bps_device",
+		"blkio.throttle.read_iops_device", "blkio.throttle.write_iops_device"},
+	UnifiedName: {"memory.max", "memory.swap.max", "memory.low", "cpu.weight", "cpu.max", "cpuset.cpus",
+		"io.weight", "io.bfq.weight", "io.max", "pids.max"},
+}
+
+// deviceResets are the values that lift the limit of a device in the files holding a line per device
+var deviceResets = map[string]string{
+	"blkio.bfq.weight":                 "default",
+	"blkio.throttle.read_bps_device":   "0",
+	"blkio.throttle.write_bps_device":  "0",
+	"blkio.throttle.read_iops_device":  "0",
+	"blkio.throttle.write_iops_device": "0",
+	"io.weight":                        "default",
+	"io.bfq.weight":                    "default",
+	"io.max":                           "rbps=max wbps=max riops=max wiops=max",
+}
+
+// Limits holds the limits of a cgroup in a subsystem, as the content of its limit files
+type Limits map[string]string
+
+// limitWrite is a value to write to a limit file
+type limitWrite struct {
+	fileURL string
+	value   string
+}
+
+// SaveLimits reads the limit files of the cgroup cgroupPath in subsystem, the ones the kernel does not have are left out
+func SaveLimits(subsystem string, cgroupPath string) (Limits, error) {
+	limits := Limits{}
+	// subsystems such as cpuacct and freezer have no limits, and may not even have the cgroup
+	if len(limitFiles[subsystem]) == 0 {
+		return limits, nil
+	}
+	subsysCgroupPath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	for _, file := range limitFiles[subsystem] {
+		fileURL := path.Join(subsysCgroupPath, file)
+		content, err := ioutil.ReadFile(fileURL)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s error %v", fileURL, err)
+		}
+		limits[fileURL] = string(content)
+	}
+	return limits, nil
+}
+
+// Restore writes back the limits that changed since they were saved
+// a limit may be refused until another one is back, such as a memory limit above the memory plus swap one,
+// so the refused ones are tried again once the rest are written
+func (l Limits) Restore() error {
+	var writes []limitWrite
+	for fileURL, saved := range l {
+		content, err := ioutil.ReadFile(fileURL)
+		if err != nil {
+			return fmt.Errorf("read %s error %v", fileURL, err)
+		}
+		writes = append(writes, restoreWrites(fileURL, saved, string(content))...)
+	}
+	var firstErr error
+	for pass := 0; pass < 2 && len(writes) > 0; pass++ {
+		var refused []limitWrite
+		firstErr = nil
+		for _, write := range writes {
+			if err := ioutil.WriteFile(write.fileURL, []byte(write.value), 0644); err != nil {
+				refused = append(refused, write)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("restore %s to %s error %v", write.fileURL, write.value, err)
+				}
+			}
+		}
+		writes = refused
+	}
+	return firstErr
+}
+
+// restoreWrites returns what to write to the limit file at fileURL to get from its current content back to saved
+func restoreWrites(fileURL string, saved string, current string) []limitWrite {
+	if saved == current {
+		return nil
+	}
+	file := path.Base(fileURL)
+	if file == "memory.oom_control" {
+		// only oom_kill_disable can be written, the other lines are counters
+		savedLine := deviceLines(saved)["oom_kill_disable"]
+		if fields := strings.Fields(savedLine); len(fields) == 2 && savedLine != deviceLines(current)["oom_kill_disable"] {
+			return []limitWrite{{fileURL, fields[1]}}
+		}
+		return nil
+	}
+	reset, perDevice := deviceResets[file]
+	if !perDevice {
+		return []limitWrite{{fileURL, strings.TrimSpace(saved)}}
+	}
+	// files such as io.max have a line per device, written one at a time, and a device not listed has no limit
+	savedLines, currentLines := deviceLines(saved), deviceLines(current)
+	var writes []limitWrite
+	for key, line := range savedLines {
+		if currentLines[key] != line {
+			writes = append(writes, limitWrite{fileURL, line})
+		}
+	}
+	for key := range currentLines {
+		if _, ok := savedLines[key]; !ok && strings.Contains(key, ":") {
+			writes = append(writes, limitWrite{fileURL, key + " " + reset})
+		}
+	}
+	return writes
+}
+
+// deviceLines maps the lines of content to their first field, a device such as 8:0, default or a key such as oom_kill_disable
+func deviceLines(content string) map[string]string {
+	lines := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines[fields[0]] = line
+		}
+	}
+	return lines
+}
@@ -0,0 +1,82 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRestoreWrites(t *testing.T) {
+	tests := []struct {
+		file           string
+		saved, current string
+		want           []string
+	}{
+		{"memory.limit_in_bytes", "104857600\n", "104857600\n", nil},
+		// a limit that had no value gets the kernel default back
+		{"memory.limit_in_bytes", "9223372036854771712\n", "52428800\n", []string{"9223372036854771712"}},
+		{"memory.max", "max\n", "52428800\n", []string{"max"}},
+		{"cpu.max", "max 100000\n", "50000 100000\n", []string{"max 100000"}},
+		{"memory.oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n", "oom_kill_disable 1\nunder_oom 0\noom_kill 0\n", []string{"0"}},
+		// only the counters changed
+		{"memory.oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 0\n", "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n", nil},
+		// devices are written one at a time, the ones that had no limit get theirs lifted
+		{"blkio.throttle.read_bps_device", "7:0 1000\n", "7:1 5000\n7:0 1000\n", []string{"7:1 0"}},
+		{"blkio.throttle.read_bps_device", "7:0 1000\n", "7:0 2000\n", []string{"7:0 1000"}},
+		{"blkio.throttle.read_bps_device", "", "7:0 2000\n", []string{"7:0 0"}},
+		{"io.max", "8:0 rbps=1000 wbps=max riops=max wiops=max\n", "8:16 rbps=max wbps=10 riops=max wiops=max\n",
+			[]string{"8:0 rbps=1000 wbps=max riops=max wiops=max", "8:16 rbps=max wbps=max riops=max wiops=max"}},
+		{"io.weight", "default 100\n", "default 200\n", []string{"default 100"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, write := range restoreWrites(path.Join("/cgroup", test.file), test.saved, test.current) {
+			if write.fileURL != path.Join("/cgroup", test.file) {
+				t.Errorf("restoreWrites(%s) writes to %s", test.file, write.fileURL)
+			}
+			got = append(got, write.value)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("restoreWrites(%s, %q, %q) = %q, want %q", test.file, test.saved, test.current, got, test.want)
+		}
+	}
+}
+
+func TestLimitsRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{"pids.max": "max\n", "cpu.shares": "1024\n"}
+	limits := Limits{}
+	for file, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		limits[path.Join(dir, file)] = content
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "pids.max"), []byte("10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := limits.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"pids.max": "max", "cpu.shares": "1024\n"}
+	for file, content := range want {
+		got, err := ioutil.ReadFile(path.Join(dir, file))
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v after Restore, want %q", file, got, err, content)
+		}
+	}
+
+	// a limit file that is gone cannot be restored
+	os.Remove(path.Join(dir, "cpu.shares"))
+	if err := limits.Restore(); err == nil {
+		t.Errorf("Restore with a missing limit file succeeded")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -20,9 +21,17 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, true); err == nil {
 		subsysName, _ := path.Split(subsysCgroupPath)
 		log.Infof("found subsystem's cgroupPath at %s", subsysName)
-		if res.MemoryLimit != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
-				return fmt.Errorf("set cgroup memory fail %v", err)
+		if err := s.setLimits(subsysCgroupPath, res); err != nil {
+			return err
+		}
+		if res.MemoryReservation != "" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), []byte(res.MemoryReservation), 0644); err != nil {
+				return fmt.Errorf("set cgroup memory reservation fail %v", err)
+			}
+		}
+		if res.OOMKillDisable {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.oom_control"), []byte("1"), 0644); err != nil {
+				return fmt.Errorf("disable cgroup OOM killer fail %v", err)
 			}
 		}
 		return nil
@@ -31,6 +40,131 @@ func (s *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+// setLimits writes the memory limit of res and the memory plus swap one, which may never be below the memory limit,
+// so whichever of the two is raised goes first
+func (s *MemorySubSystem) setLimits(subsysCgroupPath string, res *ResourceConfig) error {
+	limitURL := path.Join(subsysCgroupPath, "memory.limit_in_bytes")
+	swapURL := path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes")
+	if res.MemorySwap != "" {
+		if _, err := os.Stat(swapURL); err != nil {
+			// the kernel only accounts for swap when booted with swapaccount=1
+			log.Warnf("kernel does not support swap limit, memory swap %s is ignored", res.MemorySwap)
+			res = &ResourceConfig{MemoryLimit: res.MemoryLimit}
+		}
+	}
+	if res.MemoryLimit == "" {
+		return nil
+	}
+	if err := ioutil.WriteFile(limitURL, []byte(res.MemoryLimit), 0644); err != nil {
+		if res.MemorySwap == "" {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		// the new memory limit is above the current memory plus swap one, which has to be raised first
+		if err := ioutil.WriteFile(swapURL, []byte(res.MemorySwap), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+		if err := ioutil.WriteFile(limitURL, []byte(res.MemoryLimit), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+		return nil
+	}
+	if res.MemorySwap != "" {
+		if err := ioutil.WriteFile(swapURL, []byte(res.MemorySwap), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+	}
+	return nil
+}
+
+// MemoryUsage returns memory.usage_in_bytes of the cgroup
+func (s *MemorySubSystem) MemoryUsage(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	return readUint(path.Join(subsysCgroupPath, "memory.usage_in_bytes"))
+}
+
+// OOMKills returns the oom_kill count in memory.oom_control, which kernels before 4.13 do not have
+func (s *MemorySubSystem) OOMKills(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	oomControlURL := path.Join(subsysCgroupPath, "memory.oom_control")
+	values, err := readKeyedUints(oomControlURL)
+	if err != nil {
+		return 0, err
+	}
+	count, ok := values["oom_kill"]
+	if !ok {
+		return 0, fmt.Errorf("no oom_kill count in %s", oomControlURL)
+	}
+	return count, nil
+}
+
+// NotifyOOM registers an eventfd for memory.oom_control in cgroup.event_control,
+// the kernel signals it every time the cgroup runs out of memory, and once more when the cgroup is removed
+func (s *MemorySubSystem) NotifyOOM(cgroupPath string) (<-chan struct{}, func(), error) {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	oomControl, err := os.Open(path.Join(subsysCgroupPath, "memory.oom_control"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("open memory.oom_control error %v", err)
+	}
+	// a non-blocking eventfd is read through the runtime poller, so that closing it ends the read
+	fd, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+	if errno != 0 {
+		oomControl.Close()
+		return nil, nil, fmt.Errorf("eventfd error %v", errno)
+	}
+	eventFile := os.NewFile(fd, "eventfd")
+	control := fmt.Sprintf("%d %d", fd, oomControl.Fd())
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.event_control"), []byte(control), 0644); err != nil {
+		eventFile.Close()
+		oomControl.Close()
+		return nil, nil, fmt.Errorf("register OOM event fail %v", err)
+	}
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer oomControl.Close()
+		buf := make([]byte, 8)
+		for {
+			if _, err := eventFile.Read(buf); err != nil {
+				return
+			}
+			if _, err := os.Stat(path.Join(subsysCgroupPath, "cgroup.event_control")); err != nil {
+				// the cgroup is gone, that is the last event
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, func() { eventFile.Close() }, nil
+}
+
+// GetStats reads the memory usage and limit of the cgroup specified by cgroupPath
+func (s *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	if stats.MemoryUsage, err = readUint(path.Join(subsysCgroupPath, "memory.usage_in_bytes")); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = readUint(path.Join(subsysCgroupPath, "memory.limit_in_bytes")); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Remove removes the cgroup specified by cgroupPath
 func (s *MemorySubSystem) Remove(cgroupPath string) error {
 	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
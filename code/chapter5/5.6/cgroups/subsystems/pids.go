@@ -0,0 +1,140 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PidsSubSystem struct
+// it caps the number of processes in a cgroup, so that a fork bomb in one container cannot use up the pids of the host
+type PidsSubSystem struct {
+}
+
+// PidsStats is the number of processes in a cgroup
+type PidsStats struct {
+	Current uint64 `json:"current"` // processes in the cgroup now
+	Peak    uint64 `json:"peak"`    // the most processes the cgroup has held, 0 if the kernel does not keep track
+	Limit   uint64 `json:"limit"`   // the most processes the cgroup may hold, 0 for no limit
+}
+
+// PidsCounter is implemented by the subsystems that count the processes of a cgroup
+type PidsCounter interface {
+	// returns the number of processes in a cgroup
+	PidsStats(path string) (*PidsStats, error)
+}
+
+// Set will configure the pids limit of the cgroup designated by cgroupPath
+func (p *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, true); err == nil {
+		subsysName, _ := path.Split(subsysCgroupPath)
+		log.Infof("found subsystem's cgroupPath at %s", subsysName)
+		return setPidsMax(subsysCgroupPath, res.PidsLimit)
+	} else {
+		return err
+	}
+}
+
+// Remove removes the cgroup specified by cgroupPath
+func (p *PidsSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false); err == nil {
+		// deleting the correspoinding cgroupPath will delete the cgroup
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Apply adds a process to the cgroup specified by cgroupPath
+func (p *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	// GetCgroupPath gets the path of the current subsystem in the virtual fs
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, true); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+}
+
+// Name returns cgroup's name
+func (p *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+// PidsStats returns the number of processes in the cgroup specified by cgroupPath
+func (p *PidsSubSystem) PidsStats(cgroupPath string) (*PidsStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	return readPidsStats(subsysCgroupPath)
+}
+
+// GetStats reads the number of processes in the cgroup specified by cgroupPath
+func (p *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	pids, err := p.PidsStats(cgroupPath)
+	if os.IsNotExist(err) {
+		// the kernel does not count the processes of the cgroup
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stats.Pids = pids
+	return nil
+}
+
+// setPidsMax writes limit to pids.max, which v1 and v2 share, anything up to 0 means no limit
+func setPidsMax(subsysCgroupPath string, limit string) error {
+	if limit == "" {
+		return nil
+	}
+	if n, err := strconv.ParseInt(limit, 10, 64); err == nil && n <= 0 {
+		limit = "max"
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(limit), 0644); err != nil {
+		return fmt.Errorf("set cgroup pids limit fail %v", err)
+	}
+	return nil
+}
+
+// readPidsStats reads pids.current, pids.peak and pids.max of the cgroup at subsysCgroupPath, which v1 and v2 share
+func readPidsStats(subsysCgroupPath string) (*PidsStats, error) {
+	stats := &PidsStats{}
+	var err error
+	if stats.Current, err = readPidsFile(path.Join(subsysCgroupPath, "pids.current")); err != nil {
+		return nil, err
+	}
+	if stats.Limit, err = readPidsFile(path.Join(subsysCgroupPath, "pids.max")); err != nil {
+		return nil, err
+	}
+	// pids.peak only came with linux 6.1
+	stats.Peak, _ = readPidsFile(path.Join(subsysCgroupPath, "pids.peak"))
+	return stats, nil
+}
+
+// readPidsFile reads a count of processes from file, max reads as 0
+// the error of reading file is returned as it is, for callers to tell a missing file
+func readPidsFile(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s in %s", value, file)
+	}
+	return n, nil
+}
@@ -0,0 +1,84 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestReadPidsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		content string
+		want    uint64
+		wantErr bool
+	}{
+		{"5\n", 5, false},
+		{"0\n", 0, false},
+		// no limit reads as 0
+		{"max\n", 0, false},
+		{"", 0, true},
+		{"-1\n", 0, true},
+		{"lots\n", 0, true},
+	}
+	file := path.Join(dir, "pids.max")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readPidsFile(file)
+		if (err != nil) != test.wantErr {
+			t.Errorf("readPidsFile(%q) error %v, want error %v", test.content, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("readPidsFile(%q) = %d, want %d", test.content, got, test.want)
+		}
+	}
+	if _, err := readPidsFile(path.Join(dir, "missing")); err == nil {
+		t.Errorf("readPidsFile of a missing file succeeded")
+	}
+}
+
+func TestReadPidsStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the pids controller is not enabled for the cgroup
+	if _, err := readPidsStats(dir); !os.IsNotExist(err) {
+		t.Errorf("readPidsStats without pids.current error %v, want a not exist error", err)
+	}
+	files := map[string]string{"pids.current": "3\n", "pids.max": "max\n"}
+	for name, content := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// kernels before 6.1 have no pids.peak
+	stats, err := readPidsStats(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (PidsStats{Current: 3}) {
+		t.Errorf("readPidsStats without pids.peak = %+v, want current 3", *stats)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "pids.peak"), []byte("7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "pids.max"), []byte("10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = readPidsStats(dir); err != nil {
+		t.Fatal(err)
+	}
+	if *stats != (PidsStats{Current: 3, Peak: 7, Limit: 10}) {
+		t.Errorf("readPidsStats = %+v, want current 3, peak 7, limit 10", *stats)
+	}
+}
@@ -0,0 +1,57 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Stats is the resource usage of a cgroup, every subsystem fills in its own part
+type Stats struct {
+	CPUUsage    uint64      `json:"cpuUsage"`        // CPU time used so far in nanoseconds
+	MemoryUsage uint64      `json:"memoryUsage"`     // bytes of memory in use
+	MemoryLimit uint64      `json:"memoryLimit"`     // the most bytes of memory the cgroup may use, 0 for no limit
+	Pids        *PidsStats  `json:"pids,omitempty"`  // processes in the cgroup, nil if the kernel does not count them
+	Blkio       *BlkioStats `json:"blkio,omitempty"` // block I/O of the cgroup, nil if the kernel does not account for it
+}
+
+// BlkioStats is the block I/O of a cgroup
+type BlkioStats struct {
+	Read  uint64 `json:"read"`  // bytes read from block devices so far
+	Write uint64 `json:"write"` // bytes written to block devices so far
+}
+
+// StatsReader is implemented by the subsystems that account for the resource usage of a cgroup
+type StatsReader interface {
+	// fills in the usage of a cgroup the subsystem accounts for
+	GetStats(path string, stats *Stats) error
+}
+
+// MemoryCounter is implemented by the subsystems that account for the memory of a cgroup
+type MemoryCounter interface {
+	// returns the bytes of memory a cgroup uses
+	MemoryUsage(path string) (uint64, error)
+}
+
+// readUint reads a single number from file, max and the page-aligned maximum of v1 for no limit read as 0
+// the error of reading file is returned as it is, for callers to tell a missing file
+func readUint(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s in %s", value, file)
+	}
+	// v1 reports no limit as the largest page-aligned int64
+	if n >= 1<<62 {
+		return 0, nil
+	}
+	return n, nil
+}
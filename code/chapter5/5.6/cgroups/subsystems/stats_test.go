@@ -0,0 +1,64 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestReadUint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		content string
+		want    uint64
+		wantErr bool
+	}{
+		{"104857600\n", 104857600, false},
+		{"0\n", 0, false},
+		// no limit reads as 0, whether v2 says max or v1 gives the page-aligned maximum
+		{"max\n", 0, false},
+		{"9223372036854771712\n", 0, false},
+		{"4611686018427387904\n", 0, false},
+		{"4611686018427387903\n", 4611686018427387903, false},
+		{"", 0, true},
+		{"-1\n", 0, true},
+	}
+	file := path.Join(dir, "memory.limit_in_bytes")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readUint(file)
+		if (err != nil) != test.wantErr {
+			t.Errorf("readUint(%q) error %v, want error %v", test.content, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("readUint(%q) = %d, want %d", test.content, got, test.want)
+		}
+	}
+}
+
+func TestParseBlkioBytes(t *testing.T) {
+	content := "7:0 Read 4096\n7:0 Write 512\n7:0 Sync 4608\n7:0 Async 0\n7:0 Total 4608\n" +
+		"8:0 Read 1024\n8:0 Write 0\nTotal 5632\n"
+	if got := *parseBlkioBytes(content); got != (BlkioStats{Read: 5120, Write: 512}) {
+		t.Errorf("parseBlkioBytes = %+v, want read 5120 and write 512", got)
+	}
+	// a cgroup that did no I/O has a Total line only
+	if got := *parseBlkioBytes("Total 0\n"); got != (BlkioStats{}) {
+		t.Errorf("parseBlkioBytes of no I/O = %+v, want none", got)
+	}
+}
+
+func TestReadUintMissingFile(t *testing.T) {
+	// a missing file must be told apart from a bad one, it only makes a stat unavailable
+	if _, err := readUint("/no/such/memory.current"); !os.IsNotExist(err) {
+		t.Errorf("readUint of a missing file error %v, want a not exist error", err)
+	}
+}
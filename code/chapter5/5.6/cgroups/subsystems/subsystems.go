@@ -5,6 +5,26 @@ type ResourceConfig struct {
 	MemoryLimit string
 	CPUShare    string
 	CPUSet      string
+	CPUPeriod   string // length of a CFS period in microseconds
+	CPUQuota    string // CPU time in microseconds the cgroup gets per period, across all CPUs
+	// memory plus swap the cgroup may use, -1 for unlimited swap
+	MemorySwap string
+	// the soft limit the cgroup is pushed back to when the host runs short of memory
+	MemoryReservation string
+	// keep the OOM killer from killing the processes of the cgroup, they wait for memory instead
+	OOMKillDisable bool
+	// not a cgroup setting, the oom_score_adj of the init process, from -1000 to 1000
+	OOMScoreAdj string
+	// the most processes the cgroup may hold, 0 or -1 for no limit
+	PidsLimit string
+	// the share of block I/O the cgroup gets, from 10 to 1000
+	BlkioWeight string
+	// caps on the bytes per second read from and written to block devices
+	BlkioDeviceReadBps  []ThrottleDevice
+	BlkioDeviceWriteBps []ThrottleDevice
+	// caps on the reads and writes per second on block devices
+	BlkioDeviceReadIOps  []ThrottleDevice
+	BlkioDeviceWriteIOps []ThrottleDevice
 }
 
 // Subsystem interfaces
@@ -24,11 +44,43 @@ type Subsystem interface {
 	Remove(path string) error
 }
 
+// Freezer is implemented by the subsystems that can stop and resume the processes of a cgroup
+type Freezer interface {
+	// stops every process in a cgroup
+	Freeze(path string) error
+
+	// lets the processes of a cgroup run again
+	Thaw(path string) error
+}
+
+// OOMNotifier is implemented by the subsystems that can tell when a cgroup runs out of memory
+type OOMNotifier interface {
+	// returns a channel that receives the OOM events of a cgroup until stop is called or the cgroup is removed
+	NotifyOOM(path string) (events <-chan struct{}, stop func(), err error)
+
+	// returns how many processes of a cgroup the OOM killer has killed so far
+	OOMKills(path string) (uint64, error)
+}
+
 // use different subsystems to initialize an array of resource limit instances
+// on a cgroup v2 host, the unified hierarchy does the work of all of them
 var (
-	SubsystemsIns = []Subsystem{
+	SubsystemsIns = newSubsystemsIns()
+)
+
+func newSubsystemsIns() []Subsystem {
+	if IsCgroup2() {
+		return []Subsystem{
+			&UnifiedSubSystem{},
+		}
+	}
+	return []Subsystem{
 		&CPUsetSubSystem{},
 		&MemorySubSystem{},
 		&CPUSubSystem{},
+		&CpuacctSubSystem{},
+		&FreezerSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
 	}
-)
+}
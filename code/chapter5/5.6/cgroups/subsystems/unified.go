@@ -0,0 +1,419 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// UnifiedName is the filesystem type of the cgroup v2 hierarchy
+const UnifiedName = "cgroup2"
+
+// unifiedControllers are the controllers the cgroups of containers need in the unified hierarchy
+var unifiedControllers = []string{"cpu", "cpuset", "io", "memory", "pids"}
+
+// UnifiedSubSystem struct
+// on a cgroup v2 host, every controller shares one hierarchy, the unified one
+type UnifiedSubSystem struct {
+}
+
+// Set will configure the resource limits of the cgroup designated by cgroupPath,
+// translating the cgroup v1 ones of res into their v2 counterparts
+func (u *UnifiedSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	log.Infof("found unified cgroupPath at %s", subsysCgroupPath)
+	if err := u.enableControllers(subsysCgroupPath); err != nil {
+		return err
+	}
+	if res.MemoryLimit != "" {
+		// memory.max takes the same sizes as memory.limit_in_bytes, such as 100m
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.max"), []byte(res.MemoryLimit), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+	}
+	if res.MemorySwap != "" {
+		swap, err := unifiedSwapMax(res.MemoryLimit, res.MemorySwap)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.swap.max"), []byte(swap), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail %v", err)
+		}
+	}
+	if res.MemoryReservation != "" {
+		// memory.low protects the cgroup's memory from reclaim up to it, which is as close as v2 gets to the soft limit
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.low"), []byte(res.MemoryReservation), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory reservation fail %v", err)
+		}
+	}
+	if res.OOMKillDisable {
+		log.Warnf("cgroup v2 cannot disable the OOM killer, oom-kill-disable is ignored")
+	}
+	if res.CPUShare != "" {
+		weight, err := cpuSharesToWeight(res.CPUShare)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup CPU weight fail %v", err)
+		}
+	}
+	if res.CPUQuota != "" || res.CPUPeriod != "" {
+		// cpu.max holds both the quota, or max for none, and the period
+		quota, period := res.CPUQuota, res.CPUPeriod
+		if quota == "" || quota == "-1" {
+			quota = "max"
+		}
+		if period == "" {
+			period = "100000"
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.max"), []byte(quota+" "+period), 0644); err != nil {
+			return fmt.Errorf("set cgroup CPU max fail %v", err)
+		}
+	}
+	if res.CPUSet != "" {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CPUSet), 0644); err != nil {
+			return fmt.Errorf("set cgroup CPUset fail %v", err)
+		}
+	}
+	if err := u.setIO(subsysCgroupPath, res); err != nil {
+		return err
+	}
+	return setPidsMax(subsysCgroupPath, res.PidsLimit)
+}
+
+// setIO translates the blkio weight and throttling of res into io.weight and io.max
+func (u *UnifiedSubSystem) setIO(subsysCgroupPath string, res *ResourceConfig) error {
+	if res.BlkioWeight != "" {
+		weight, err := blkioWeightToIOWeight(res.BlkioWeight)
+		if err != nil {
+			return err
+		}
+		// io.weight is there with the io controller, io.bfq.weight with the BFQ scheduler
+		weightURL, value := path.Join(subsysCgroupPath, "io.weight"), "default "+strconv.FormatUint(weight, 10)
+		if _, err := os.Stat(weightURL); err != nil {
+			weightURL, value = path.Join(subsysCgroupPath, "io.bfq.weight"), res.BlkioWeight
+		}
+		if _, err := os.Stat(weightURL); err != nil {
+			log.Warnf("kernel does not support io weight, blkio weight %s is ignored", res.BlkioWeight)
+		} else if err := ioutil.WriteFile(weightURL, []byte(value), 0644); err != nil {
+			return fmt.Errorf("set cgroup io weight fail %v", err)
+		}
+	}
+	// io.max holds every limit of a device on one line, such as 8:0 rbps=1048576 wiops=100
+	var devices []string
+	limits := map[string][]string{}
+	throttles := []struct {
+		key     string
+		devices []ThrottleDevice
+	}{
+		{"rbps", res.BlkioDeviceReadBps},
+		{"wbps", res.BlkioDeviceWriteBps},
+		{"riops", res.BlkioDeviceReadIOps},
+		{"wiops", res.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			dev := fmt.Sprintf("%d:%d", device.Major, device.Minor)
+			if _, ok := limits[dev]; !ok {
+				devices = append(devices, dev)
+			}
+			limits[dev] = append(limits[dev], fmt.Sprintf("%s=%d", throttle.key, device.Rate))
+		}
+	}
+	for _, dev := range devices {
+		line := dev + " " + strings.Join(limits[dev], " ")
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "io.max"), []byte(line), 0644); err != nil {
+			return fmt.Errorf("set cgroup io max %s fail %v", line, err)
+		}
+	}
+	return nil
+}
+
+// Remove removes the cgroup specified by cgroupPath
+func (u *UnifiedSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false); err == nil {
+		// a cgroup v2 directory only holds interface files, rmdir deletes the cgroup
+		return os.Remove(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Apply adds a process to the cgroup specified by cgroupPath
+func (u *UnifiedSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, true); err == nil {
+		// there is no tasks file in cgroup v2, processes move with cgroup.procs
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+}
+
+// Name returns cgroup's name
+func (u *UnifiedSubSystem) Name() string {
+	return UnifiedName
+}
+
+// Freeze stops every process in the cgroup specified by cgroupPath until Thaw
+func (u *UnifiedSubSystem) Freeze(cgroupPath string) error {
+	return u.setFrozen(cgroupPath, "1")
+}
+
+// Thaw lets the processes in the cgroup specified by cgroupPath run again
+func (u *UnifiedSubSystem) Thaw(cgroupPath string) error {
+	return u.setFrozen(cgroupPath, "0")
+}
+
+// setFrozen writes frozen to cgroup.freeze and waits for cgroup.events to report the cgroup in that state
+func (u *UnifiedSubSystem) setFrozen(cgroupPath string, frozen string) error {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.freeze"), []byte(frozen), 0644); err != nil {
+		return fmt.Errorf("set cgroup freeze fail %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		events, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("read cgroup events fail %v", err)
+		}
+		for _, line := range strings.Split(string(events), "\n") {
+			if line == "frozen "+frozen {
+				return nil
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return fmt.Errorf("cgroup %s did not get frozen %s", cgroupPath, frozen)
+}
+
+// GetStats reads the usage of the cgroup specified by cgroupPath from the files of every controller
+func (u *UnifiedSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	if stats.MemoryUsage, err = readUint(path.Join(subsysCgroupPath, "memory.current")); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = readUint(path.Join(subsysCgroupPath, "memory.max")); err != nil {
+		return err
+	}
+	cpuStat, err := readKeyedUints(path.Join(subsysCgroupPath, "cpu.stat"))
+	if err != nil {
+		return err
+	}
+	stats.CPUUsage = cpuStat["usage_usec"] * 1000
+	// the files of the pids and io controllers are only there if the controllers are enabled for the cgroup
+	pids, err := readPidsStats(subsysCgroupPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	stats.Pids = pids
+	// io.stat has a line per device, such as 8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0
+	ioStatURL := path.Join(subsysCgroupPath, "io.stat")
+	content, err := ioutil.ReadFile(ioStatURL)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s error %v", ioStatURL, err)
+	}
+	stats.Blkio = &BlkioStats{}
+	for _, field := range strings.Fields(string(content)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(kv[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch kv[0] {
+		case "rbytes":
+			stats.Blkio.Read += n
+		case "wbytes":
+			stats.Blkio.Write += n
+		}
+	}
+	return nil
+}
+
+// PidsStats returns the number of processes in the cgroup specified by cgroupPath
+func (u *UnifiedSubSystem) PidsStats(cgroupPath string) (*PidsStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	return readPidsStats(subsysCgroupPath)
+}
+
+// NotifyOOM watches memory.events of the cgroup with inotify, the kernel touches it on every memory event,
+// and the oom_kill count in it tells whether one was the OOM killer
+func (u *UnifiedSubSystem) NotifyOOM(cgroupPath string) (<-chan struct{}, func(), error) {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	eventsURL := path.Join(subsysCgroupPath, "memory.events")
+	oomKills, err := readOOMKills(eventsURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	// a non-blocking inotify fd is read through the runtime poller, so that closing it ends the read
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, fmt.Errorf("inotify init error %v", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, eventsURL, syscall.IN_MODIFY); err != nil {
+		syscall.Close(fd)
+		return nil, nil, fmt.Errorf("watch %s error %v", eventsURL, err)
+	}
+	inotifyFile := os.NewFile(uintptr(fd), "inotify")
+
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, syscall.SizeofInotifyEvent*16)
+		for {
+			if _, err := inotifyFile.Read(buf); err != nil {
+				return
+			}
+			// the file is gone along with the cgroup
+			count, err := readOOMKills(eventsURL)
+			if err != nil {
+				return
+			}
+			if count > oomKills {
+				oomKills = count
+				select {
+				case events <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return events, func() { inotifyFile.Close() }, nil
+}
+
+// MemoryUsage returns memory.current of the cgroup
+func (u *UnifiedSubSystem) MemoryUsage(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	return readUint(path.Join(subsysCgroupPath, "memory.current"))
+}
+
+// OOMKills returns the oom_kill count in memory.events
+func (u *UnifiedSubSystem) OOMKills(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := GetCgroupPath(u.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, fmt.Errorf("get cgroup %s error %v", cgroupPath, err)
+	}
+	return readOOMKills(path.Join(subsysCgroupPath, "memory.events"))
+}
+
+// readOOMKills returns the oom_kill count in memory.events
+func readOOMKills(eventsURL string) (uint64, error) {
+	content, err := ioutil.ReadFile(eventsURL)
+	if err != nil {
+		return 0, fmt.Errorf("read %s error %v", eventsURL, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, nil
+}
+
+// unifiedSwapMax translates the memory plus swap limit of v1 into memory.swap.max, which only counts swap
+func unifiedSwapMax(memoryLimit string, memorySwap string) (string, error) {
+	if memorySwap == "-1" {
+		return "max", nil
+	}
+	swap, err := ParseMemory(memorySwap)
+	if err != nil {
+		return "", err
+	}
+	memory, err := ParseMemory(memoryLimit)
+	if err != nil {
+		return "", err
+	}
+	if swap < memory {
+		return "", fmt.Errorf("memory swap %s is below memory limit %s", memorySwap, memoryLimit)
+	}
+	return strconv.FormatInt(swap-memory, 10), nil
+}
+
+// enableControllers enables the controllers of the container cgroup at subsysCgroupPath in cgroup.subtree_control of its parents,
+// from the root of the hierarchy down, a controller is only available in a cgroup its parent has enabled it for
+func (u *UnifiedSubSystem) enableControllers(subsysCgroupPath string) error {
+	root := FindCgroupMountpoint(u.Name())
+	available, err := ioutil.ReadFile(path.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("read cgroup controllers fail %v", err)
+	}
+	var controllers []string
+	for _, controller := range unifiedControllers {
+		for _, name := range strings.Fields(string(available)) {
+			if name == controller {
+				controllers = append(controllers, "+"+controller)
+			}
+		}
+	}
+	if len(controllers) == 0 {
+		return nil
+	}
+	parent := root
+	for _, name := range strings.Split(strings.TrimPrefix(path.Dir(subsysCgroupPath), root), "/") {
+		if name != "" {
+			parent = path.Join(parent, name)
+		}
+		// the parents themselves hold no processes, which a cgroup with subtree controllers may not
+		if err := ioutil.WriteFile(path.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644); err != nil {
+			return fmt.Errorf("enable controllers %v in cgroup %s fail %v", controllers, parent, err)
+		}
+	}
+	return nil
+}
+
+// blkioWeightToIOWeight translates blkio.weight, from 10 to 1000, into io.weight, from 1 to 10000
+func blkioWeightToIOWeight(blkioWeight string) (uint64, error) {
+	weight, err := strconv.ParseUint(blkioWeight, 10, 64)
+	if err != nil || weight < 10 || weight > 1000 {
+		return 0, fmt.Errorf("invalid blkio weight %s", blkioWeight)
+	}
+	return 1 + ((weight-10)*9999)/990, nil
+}
+
+// cpuSharesToWeight translates cpu.shares, from 2 to 262144, into cpu.weight, from 1 to 10000
+func cpuSharesToWeight(cpuShares string) (uint64, error) {
+	shares, err := strconv.ParseUint(cpuShares, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpushare %s", cpuShares)
+	}
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142, nil
+}
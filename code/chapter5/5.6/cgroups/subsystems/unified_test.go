@@ -0,0 +1,124 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestUnifiedSwapMax(t *testing.T) {
+	tests := []struct {
+		memory, swap string
+		want         string
+		wantErr      bool
+	}{
+		// memory.swap.max only counts the swap, memory-swap counts the memory as well
+		{"104857600", "209715200", "104857600", false},
+		{"100m", "300m", "209715200", false},
+		{"100m", "100m", "0", false},
+		{"100m", "-1", "max", false},
+		{"", "-1", "max", false},
+		{"200m", "100m", "", true},
+		{"100m", "lots", "", true},
+		{"", "100m", "", true},
+	}
+	for _, test := range tests {
+		got, err := unifiedSwapMax(test.memory, test.swap)
+		if (err != nil) != test.wantErr {
+			t.Errorf("unifiedSwapMax(%q, %q) error %v, want error %v", test.memory, test.swap, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("unifiedSwapMax(%q, %q) = %q, want %q", test.memory, test.swap, got, test.want)
+		}
+	}
+}
+
+func TestCPUSharesToWeight(t *testing.T) {
+	tests := []struct {
+		shares  string
+		want    uint64
+		wantErr bool
+	}{
+		{"2", 1, false},
+		{"1024", 39, false},
+		{"262144", 10000, false},
+		// shares out of the range of cpu.shares are clamped like the kernel does
+		{"0", 1, false},
+		{"1000000", 10000, false},
+		{"-1", 0, true},
+		{"x", 0, true},
+	}
+	for _, test := range tests {
+		got, err := cpuSharesToWeight(test.shares)
+		if (err != nil) != test.wantErr {
+			t.Errorf("cpuSharesToWeight(%q) error %v, want error %v", test.shares, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cpuSharesToWeight(%q) = %d, want %d", test.shares, got, test.want)
+		}
+	}
+}
+
+func TestReadOOMKills(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		content string
+		want    uint64
+		wantErr bool
+	}{
+		{"low 0\nhigh 0\nmax 12\noom 3\noom_kill 2\noom_group_kill 0\n", 2, false},
+		{"low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n", 0, false},
+		// kernels before 4.13 do not count the OOM kills
+		{"low 0\nhigh 0\nmax 0\noom 0\n", 0, false},
+		{"oom_kill many\n", 0, true},
+	}
+	eventsURL := path.Join(dir, "memory.events")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(eventsURL, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readOOMKills(eventsURL)
+		if (err != nil) != test.wantErr {
+			t.Errorf("readOOMKills(%q) error %v, want error %v", test.content, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("readOOMKills(%q) = %d, want %d", test.content, got, test.want)
+		}
+	}
+	if _, err := readOOMKills(path.Join(dir, "missing")); err == nil {
+		t.Errorf("readOOMKills of a missing file succeeded")
+	}
+}
+
+func TestBlkioWeightToIOWeight(t *testing.T) {
+	tests := []struct {
+		weight  string
+		want    uint64
+		wantErr bool
+	}{
+		{"10", 1, false},
+		{"500", 4950, false},
+		{"1000", 10000, false},
+		{"9", 0, true},
+		{"1001", 0, true},
+		{"heavy", 0, true},
+	}
+	for _, test := range tests {
+		got, err := blkioWeightToIOWeight(test.weight)
+		if (err != nil) != test.wantErr {
+			t.Errorf("blkioWeightToIOWeight(%q) error %v, want error %v", test.weight, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("blkioWeightToIOWeight(%q) = %d, want %d", test.weight, got, test.want)
+		}
+	}
+}
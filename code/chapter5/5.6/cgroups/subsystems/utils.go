@@ -3,13 +3,15 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
 // FindCgroupMountpoint uses /proc/self/mountinfo to find the root directory
-// of the hierarchy cgroup that's mounted to a subsystem
+// of the hierarchy cgroup that's mounted to a subsystem, or of the cgroup v2 hierarchy for UnifiedName
 func FindCgroupMountpoint(subsystem string) string {
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
@@ -21,6 +23,13 @@ func FindCgroupMountpoint(subsystem string) string {
 	for scanner.Scan() {
 		txt := scanner.Text()
 		fields := strings.Split(txt, " ")
+		if subsystem == UnifiedName {
+			// the unified hierarchy has no subsystem in its options, it is told apart by its filesystem type after the " - "
+			if i := strings.Index(txt, " - "); i >= 0 && strings.HasPrefix(txt[i+3:], UnifiedName+" ") {
+				return fields[4]
+			}
+			continue
+		}
 		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
 			if opt == subsystem {
 				return fields[4]
@@ -37,9 +46,13 @@ func FindCgroupMountpoint(subsystem string) string {
 // GetCgroupPath gets the abs path of the cgroup
 func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountpoint(subsystem)
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("subsystem %s is not mounted", subsystem)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
+			// the cgroup may be nested in parents that do not exist yet, such as mydocker/<id>
+			if err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err == nil {
 			} else {
 				return "", fmt.Errorf("error create cgroup %v", err)
 			}
@@ -49,3 +62,55 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		return "", fmt.Errorf("error create cgroup %v", err)
 	}
 }
+
+// IsCgroup2 returns if the host only has the cgroup v2 hierarchy
+// a hybrid host keeps the controllers in v1 hierarchies, with an empty v2 hierarchy next to them, and counts as v1
+func IsCgroup2() bool {
+	return FindCgroupMountpoint("memory") == "" && FindCgroupMountpoint(UnifiedName) != ""
+}
+
+// ParseMemory parses a memory size such as 100m into bytes, the way memory.limit_in_bytes does,
+// with an optional b after the k, m, g or t suffix
+func ParseMemory(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(size)), "b")
+	var shift uint
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			shift = 10
+		case 'm':
+			shift = 20
+		case 'g':
+			shift = 30
+		case 't':
+			shift = 40
+		}
+		if shift != 0 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid memory size %s", size)
+	}
+	return n << shift, nil
+}
+
+// readKeyedUints reads the numbers of a file of key value lines, such as memory.oom_control, into a map
+func readKeyedUints(file string) (map[string]uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s error %v", file, err)
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values, nil
+}
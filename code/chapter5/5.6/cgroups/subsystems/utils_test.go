@@ -0,0 +1,38 @@
+package subsystems
+
+import "testing"
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"4096", 4096, false},
+		{"4096b", 4096, false},
+		{"64k", 64 << 10, false},
+		{"64kb", 64 << 10, false},
+		{"100m", 100 << 20, false},
+		{"100MB", 100 << 20, false},
+		{" 2g ", 2 << 30, false},
+		{"1t", 1 << 40, false},
+		{"4194304t", 1 << 62, false},
+		{"4194305t", 0, true},
+		{"", 0, true},
+		{"m", 0, true},
+		{"-1", 0, true},
+		{"1.5g", 0, true},
+		{"10x", 0, true},
+	}
+	for _, test := range tests {
+		got, err := ParseMemory(test.size)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseMemory(%q) error %v, want error %v", test.size, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseMemory(%q) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
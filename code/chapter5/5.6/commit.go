@@ -2,18 +2,43 @@ package main
 
 import (
 	"fmt"
-	"os/exec"
 
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/image"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
 	log "github.com/sirupsen/logrus"
 )
 
-// commitContainer packages the container fs into ${imageName}.tar
-func commitContainer(imageName string) {
-	mntURL := "/root/mnt"
-	imageTar := "/root/" + imageName + ".tar"
-	fmt.Printf("packaging destination %s\n", imageTar)
-	if _, err := exec.Command("tar", "czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		log.Errorf("tar folder %s error %v", mntURL, err)
+// commitContainer exports the write layer of container containerName as a new layer
+// and saves image imageName as the container's image layers plus that layer
+func commitContainer(containerName string, imageName string) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		log.Errorf("get container %s's info error %v", containerName, err)
+		return
 	}
-	fmt.Printf("successfully packaged %s to %s\n", mntURL, imageTar)
+	// the driver that mounted the container knows which files of the write layer are whiteouts
+	driver, err := storage.GetDriver(containerInfo.StorageDriver)
+	if err != nil {
+		log.Errorf("get storage driver of container %s error %v", containerName, err)
+		return
+	}
+	writeURL := containerInfo.WriteLayerURL
+	fmt.Printf("packaging write layer %s\n", writeURL)
+	digest, err := image.CreateLayer(writeURL, driver)
+	if err != nil {
+		log.Errorf("create layer from %s error %v", writeURL, err)
+		return
+	}
+	// the layers under the write layer are already in the store, so they are only referenced
+	layers := append(append([]string{}, containerInfo.Layers...), digest)
+	// the new image keeps the defaults of the container's image
+	var config image.Config
+	if baseImage, err := image.GetImage(containerInfo.Image); err == nil {
+		config = baseImage.Config
+	}
+	if err := image.NewImage(imageName, layers, config).Save(); err != nil {
+		log.Errorf("save image %s error %v", imageName, err)
+		return
+	}
+	fmt.Printf("successfully committed %s to image %s with layer %s\n", containerName, imageName, digest)
 }
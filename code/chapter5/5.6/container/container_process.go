@@ -2,33 +2,76 @@ package container
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/image"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
 	log "github.com/sirupsen/logrus"
 )
 
 // Info stores data about the container
 type Info struct {
-	Id           string `json:"pid`          // the PID of the init process of the container on the host
-	Pid          string `json:"id`           // container id
-	Name         string `json:"name`         //container name
-	Command      string `json:"command`      //the command of the init process runs inside the container
-	CreationTime string `json:"creationTime` //the creation time of the container
-	Status       string `json:"status`       // the status of the container
+	Id              string                     `json:"id"`              // container id
+	Pid             string                     `json:"pid"`             // the PID of the init process of the container on the host
+	Name            string                     `json:"name"`            // container name
+	Command         string                     `json:"command"`         // the command of the init process runs inside the container
+	CreationTime    string                     `json:"creationTime"`    // the creation time of the container
+	Status          string                     `json:"status"`          // the status of the container
+	Image           string                     `json:"image"`           // the image the container is started from
+	Layers          []string                   `json:"layers"`          // digests of the image layers under the write layer, bottom-most first
+	Volume          string                     `json:"volume"`          // the data volume mounted into the container, in the form of host:container
+	MntURL          string                     `json:"mntUrl"`          // the mount point of the container's root filesystem
+	WriteLayerURL   string                     `json:"writeLayerUrl"`   // the write layer of the container
+	WorkURL         string                     `json:"workUrl"`         // the scratch dir of the storage driver
+	StorageDriver   string                     `json:"storageDriver"`   // the storage driver that mounted the root filesystem
+	Env             []string                   `json:"env"`             // the environment of the container in the form of KEY=VALUE
+	Args            []string                   `json:"args"`            // argv of the init process, with the entrypoint of the image applied
+	Cwd             string                     `json:"cwd"`             // the working dir of the init process
+	User            string                     `json:"user"`            // the user[:group] running the init process
+	StopSignal      string                     `json:"stopSignal"`      // the signal stop sends to the init process, SIGTERM if not set
+	CgroupPath      string                     `json:"cgroupPath"`      // the path of the container's cgroup in every hierarchy
+	Resources       *subsystems.ResourceConfig `json:"resources"`       // the resource limits of the container
+	Rlimits         []Rlimit                   `json:"rlimits"`         // the resource limits of the init process, set with setrlimit
+	ExitCode        int                        `json:"exitCode"`        // the exit code of the init process, 128+N if it was killed by signal N
+	FinishedAt      string                     `json:"finishedAt"`      // the time the init process exited
+	OOMKilled       bool                       `json:"oomKilled"`       // the OOM killer struck the container before it exited
+	AutoRemove      bool                       `json:"autoRemove"`      // remove the container once it exits
+	RestartPolicy   RestartPolicy              `json:"restartPolicy"`   // whether the shim starts the container again once it exits
+	RestartCount    int                        `json:"restartCount"`    // how many times the shim has started the container again
+	ManuallyStopped bool                       `json:"manuallyStopped"` // the container was stopped by the user and is not to be restarted
+}
+
+// RestartPolicy tells the shim of a detached container whether to start it again once it exits
+type RestartPolicy struct {
+	Name              string `json:"name"`              // no, on-failure, always or unless-stopped
+	MaximumRetryCount int    `json:"maximumRetryCount"` // the most restarts of on-failure, 0 for no limit
 }
 
 // some constants
 var (
+	CREATED             = "Created"
 	RUNNING             = "Running"
+	RESTARTING          = "Restarting"
+	PAUSED              = "Paused"
 	STOP                = "Stopped"
 	EXIT                = "Exited"
 	DefaultInfoLocation = "/var/run/mydocker/%s/"
 	ConfigName          = "config.json"
 	ContainerLogFile    = "container.log"
+	ShimLogFile         = "shim.log"
+	StartFifoName       = "start.fifo"
+	MntURL              = "/root/mnt/%s/"
+	WriteLayerURL       = "/root/writeLayer/%s/"
+	WorkURL             = "/root/work/%s/"
+	DefaultCgroupParent = "mydocker"
+	DefaultPathEnv      = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 )
 
 /*
@@ -38,14 +81,17 @@ var (
 	2. args is the parameters, with "init" being the first argument passed to the process
 	3. the clone arguments forks a new process and uses namespace for isolation
 	4. if user specifies "-ti", then I/O of the process is redirected to std I/O
+	5. the process runs in the root filesystem of the container at MntURL, which NewWorkSpace has to set up first
 */
-func NewParentProcess(tty bool, containerName string, volume string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, containerName string) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := NewPipe()
 	if err != nil {
 		log.Errorf("new pipe error %v", err)
 		return nil, nil
 	}
 	cmd := exec.Command("/proc/self/exe", "init")
+	// init gets the env of the user's command through the pipe, it needs none of the host's
+	cmd.Env = []string{}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
@@ -61,24 +107,41 @@ func NewParentProcess(tty bool, containerName string, volume string) (*exec.Cmd,
 			return nil, nil
 		}
 		stdLogFilePath := path.Join(dirURL, ContainerLogFile)
-		stdLogFile, err := os.Create(stdLogFilePath)
+		// a restarted container adds to the log of its previous runs
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
 			log.Errorf("NewParentProcess() create log file %s error %v", stdLogFilePath, err)
 		}
 		cmd.Stdout = stdLogFile
+		cmd.Stderr = stdLogFile
 	}
 
 	// pass handle for the read end of the pipe
 	// child process will be created with the readPipe as the 4th file descriptor (after Stdin, Stdout, Stderr)
 	cmd.ExtraFiles = []*os.File{readPipe}
-	mntURL := "/root/mnt/"
-	rootURL := "/root/"
-	NewWorkSpace(rootURL, mntURL, volume)
-	cmd.Dir = mntURL
+	cmd.Dir = fmt.Sprintf(MntURL, containerName)
 
 	return cmd, writePipe
 }
 
+// MergeEnv returns the environment of a container: the image env imageEnv overridden by the user's userEnv
+// a default PATH is added if there is none, since the command is looked up in it
+func MergeEnv(imageEnv []string, userEnv []string) []string {
+	var env []string
+	index := make(map[string]int)
+	for _, kv := range append(append([]string{DefaultPathEnv}, imageEnv...), userEnv...) {
+		key := strings.SplitN(kv, "=", 2)[0]
+		if i, ok := index[key]; ok {
+			// a later value of the same key wins, but keeps the place of the first one
+			env[i] = kv
+			continue
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	return env
+}
+
 // NewPipe creates an anonymous pipe and returns two files: read and write
 func NewPipe() (*os.File, *os.File, error) {
 	read, write, err := os.Pipe()
@@ -88,49 +151,47 @@ func NewPipe() (*os.File, *os.File, error) {
 	return read, write, err
 }
 
-// NewWorkSpace create an AUFS filesystem as the container root workspace
-func NewWorkSpace(rootURL string, mntURL string, volume string) {
-	CreateReadOnlyLayer(rootURL)
-	CreateWriteLayer(rootURL)
-	CreateMountPoint(rootURL, mntURL)
+// NewWorkSpace uses the storage driver to create the container root workspace
+func NewWorkSpace(volume string, img *image.Image, containerName string, driver storage.Driver) error {
+	mntURL := fmt.Sprintf(MntURL, containerName)
+	writeURL := fmt.Sprintf(WriteLayerURL, containerName)
+	workURL := fmt.Sprintf(WorkURL, containerName)
+	layerURLs, err := CreateReadOnlyLayer(img, driver)
+	if err != nil {
+		return err
+	}
+	CreateWriteLayer(writeURL)
+	if err := CreateMountPoint(driver, layerURLs, writeURL, workURL, mntURL); err != nil {
+		return err
+	}
 	// determines if we will mount the data volume depending on "volume"
 	if volume != "" {
 		volumeURLs := volumeURLExtract(volume)
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(mntURL, volumeURLs)
+			MountVolume(driver, mntURL, volumeURLs)
 			log.Infof("mounted volumes: %q", volumeURLs)
 		} else {
 			log.Infof("volume parameter not correctly set!")
 		}
 	}
+	return nil
 }
 
-// CreateReadOnlyLayer untars busybox.tar to busybox to use as the container's read-only layer
-func CreateReadOnlyLayer(rootURL string) {
-	busyboxURL := path.Join(rootURL, "busybox")
-	busyboxTarURL := path.Join(rootURL, "busybox.tar")
-	exist, err := PathExists(busyboxURL)
+// CreateReadOnlyLayer unpacks the layers of img to use as the container's read-only layers
+// the layers are shared by all containers of all images using them, so each is only unpacked once
+func CreateReadOnlyLayer(img *image.Image, driver storage.Driver) ([]string, error) {
+	layerURLs, err := img.LayerURLs(driver)
 	if err != nil {
-		log.Infof("failed to tell whether dir %s exists. %v", busyboxURL, err)
-	}
-	if exist == false {
-		if err := os.Mkdir(busyboxURL, 0777); err != nil {
-			log.Errorf("mkdir dir %s error. %v", busyboxURL, err)
-		}
-		if _, err := exec.Command("tar", "-xvf", busyboxTarURL, "-C", busyboxURL).CombinedOutput(); err != nil {
-			log.Errorf("untar file %s error. %v", busyboxTarURL, err)
-		}
-		log.Infof("untared busybox.tar to %s", busyboxURL)
-	} else {
-		log.Infof("%s already exists, skipping untar process", busyboxURL)
+		return nil, fmt.Errorf("unpack layers of image %s error %v", img.Name, err)
 	}
+	log.Infof("using %d layers of image %s", len(layerURLs), img.Name)
+	return layerURLs, nil
 }
 
-// CreateWriteLayer creates a writeLayer folder as the container's only write layer
-func CreateWriteLayer(rootURL string) {
-	writeURL := path.Join(rootURL, "writeLayer")
-	if err := os.Mkdir(writeURL, 0777); err != nil {
+// CreateWriteLayer creates the writeURL folder as the container's only write layer
+func CreateWriteLayer(writeURL string) {
+	if err := os.MkdirAll(writeURL, 0777); err != nil {
 		log.Errorf("mkdir dir %s error. %v", writeURL, err)
 	} else {
 		log.Infof("created directory %s", writeURL)
@@ -138,24 +199,19 @@ func CreateWriteLayer(rootURL string) {
 
 }
 
-// CreateMountPoint mounts writeLayer and busybox under mnt
-func CreateMountPoint(rootURL string, mntURL string) {
+// CreateMountPoint mounts the container's write layer and the image layers under mntURL
+func CreateMountPoint(driver storage.Driver, layerURLs []string, writeURL string, workURL string, mntURL string) error {
 	// create mnt folder as the mount point
-	if err := os.Mkdir(mntURL, 0777); err != nil {
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
 		log.Errorf("mkdir dir %s error %v", mntURL, err)
 	} else {
 		log.Infof("created directory %s", mntURL)
 	}
 
-	dirs := "dirs=" + rootURL + "writeLayer:" + rootURL + "busybox"
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("mount %v", err)
-	} else {
-		log.Infof("\"mount -t aufs -o %s none %s\" successful", dirs, mntURL)
+	if err := driver.Mount(layerURLs, writeURL, workURL, mntURL); err != nil {
+		return fmt.Errorf("%s mount error %v", driver.Name(), err)
 	}
+	return nil
 }
 
 // volumeUrlExtract analyzes the volume string
@@ -166,7 +222,7 @@ func volumeURLExtract(volume string) []string {
 }
 
 // MountVolume mounts volumeURLS[0], which is a directory on the host onto volumeURL[1] inside the container
-func MountVolume(mntURL string, volumeURLs []string) {
+func MountVolume(driver storage.Driver, mntURL string, volumeURLs []string) {
 	// creates parentURL on the host
 	parentURL := volumeURLs[0]
 	if err := os.Mkdir(parentURL, 0777); err != nil {
@@ -182,58 +238,99 @@ func MountVolume(mntURL string, volumeURLs []string) {
 		log.Infof("created directory %s as volume dir on container", containerVolumeURL)
 	}
 	// mount parentURL to the container mount point containerVolumeURL
-	dirs := "dirs=" + parentURL
-	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := driver.MountVolume(parentURL, containerVolumeURL); err != nil {
 		log.Errorf("mount volume failed %v", err)
-	} else {
-		log.Infof("\"mount -t aufs -o %s none %s\" successful", dirs, containerVolumeURL)
 	}
 }
 
-// DeleteWorkSpace deletes the AUFS filesystem at container exit
-func DeleteWorkSpace(rootURL string, mntURL string, volume string) {
-	if volume != "" {
-		volumeURLs := volumeURLExtract(volume)
+// DeleteWorkSpace deletes the root filesystem of the container described by info
+func DeleteWorkSpace(info *Info) {
+	driver, err := storage.GetDriver(info.StorageDriver)
+	if err != nil {
+		log.Errorf("get storage driver of container %s error %v", info.Name, err)
+		return
+	}
+	mntURL := info.MntURL
+	if info.Volume != "" {
+		volumeURLs := volumeURLExtract(info.Volume)
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			DeleteMountPointWithVolume(rootURL, mntURL, volumeURLs)
+			DeleteMountPointWithVolume(driver, mntURL, volumeURLs)
 		} else {
-			DeleteMountPoint(mntURL)
+			DeleteMountPoint(driver, mntURL)
 		}
 	} else {
-		DeleteMountPoint(mntURL)
+		DeleteMountPoint(driver, mntURL)
+	}
+	DeleteWriteLayer(info.WriteLayerURL, info.WorkURL)
+}
+
+// MountWorkSpace mounts the root filesystem and the volume of the container described by info again,
+// on top of the write layer it kept from its previous run
+func MountWorkSpace(info *Info) error {
+	if IsMounted(info.MntURL) {
+		return nil
+	}
+	driver, err := storage.GetDriver(info.StorageDriver)
+	if err != nil {
+		return fmt.Errorf("get storage driver of container %s error %v", info.Name, err)
+	}
+	// the image may be gone or changed by now, the layers the container was created with are what counts
+	img := image.NewImage(info.Image, info.Layers, image.Config{})
+	return NewWorkSpace(info.Volume, img, info.Name, driver)
+}
+
+// UnmountWorkSpace unmounts the volume and the root filesystem of the container described by info once it has exited,
+// the write layer stays in place, so the container can still be committed
+func UnmountWorkSpace(info *Info) error {
+	driver, err := storage.GetDriver(info.StorageDriver)
+	if err != nil {
+		return fmt.Errorf("get storage driver of container %s error %v", info.Name, err)
+	}
+	if info.Volume != "" {
+		volumeURLs := volumeURLExtract(info.Volume)
+		if len(volumeURLs) == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
+			UnmountVolume(driver, info.MntURL, volumeURLs)
+		}
+	}
+	if !IsMounted(info.MntURL) {
+		return nil
 	}
-	DeleteWriteLayer(rootURL)
+	if err := driver.Unmount(info.MntURL); err != nil {
+		return fmt.Errorf("unmount %s error %v", info.MntURL, err)
+	}
+	log.Infof("unmounted %s", info.MntURL)
+	return nil
 }
 
 // DeleteMountPointWithVolume deletes mount points along with volumes
-func DeleteMountPointWithVolume(rootURL string, mntURL string, volumeURLs []string) {
+func DeleteMountPointWithVolume(driver storage.Driver, mntURL string, volumeURLs []string) {
 	// unmount the fs on the volume mount point
+	UnmountVolume(driver, mntURL, volumeURLs)
+	// umount the mount point of the container and delete it
+	DeleteMountPoint(driver, mntURL)
+}
+
+// UnmountVolume unmounts the volume volumeURLs[1] inside the container if it is still mounted
+func UnmountVolume(driver storage.Driver, mntURL string, volumeURLs []string) {
 	containerVolumeURL := path.Join(mntURL, volumeURLs[1])
-	cmd := exec.Command("umount", containerVolumeURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if !IsMounted(containerVolumeURL) {
+		return
+	}
+	if err := driver.Unmount(containerVolumeURL); err != nil {
 		log.Errorf("unmount volume failed %v", err)
-	} else {
-		log.Infof("\"umount %s\" successful", containerVolumeURL)
 	}
-	// umount the mount point of the container and delete it
-	DeleteMountPoint(mntURL)
 }
 
 // DeleteMountPoint unmounts and removes mnt
-func DeleteMountPoint(mntURL string) {
-	cmd := exec.Command("umount", mntURL)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		log.Errorf("unmount error %v", err)
-	} else {
-		log.Infof("\"umount %s\" successful", mntURL)
+func DeleteMountPoint(driver storage.Driver, mntURL string) {
+	// the mount point is already unmounted once the container has exited
+	if IsMounted(mntURL) {
+		if err := driver.Unmount(mntURL); err != nil {
+			// removing a dir that is still mounted would delete the contents of the layers
+			log.Errorf("unmount error %v", err)
+			return
+		}
 	}
 	if err := os.RemoveAll(mntURL); err != nil {
 		log.Errorf("remove dir %s error %v", mntURL, err)
@@ -242,14 +339,52 @@ func DeleteMountPoint(mntURL string) {
 	}
 }
 
-// DeleteWriteLayer deletes writeLayer
-func DeleteWriteLayer(rootURL string) {
-	writeURL := path.Join(rootURL, "writeLayer")
-	if err := os.RemoveAll(writeURL); err != nil {
-		log.Errorf("remove dir %s error %v", writeURL, err)
-	} else {
-		log.Infof("deleted directory %s", writeURL)
+// DeleteWriteLayer deletes the container's write layer at writeURL and the driver's scratch dir at workURL
+func DeleteWriteLayer(writeURL string, workURL string) {
+	for _, dirURL := range []string{writeURL, workURL} {
+		if dirURL == "" {
+			continue
+		}
+		if err := os.RemoveAll(dirURL); err != nil {
+			log.Errorf("remove dir %s error %v", dirURL, err)
+		} else {
+			log.Infof("deleted directory %s", dirURL)
+		}
+	}
+}
+
+// IsMounted returns if a filesystem is mounted on dirURL, according to /proc/self/mountinfo
+func IsMounted(dirURL string) bool {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		log.Errorf("read mountinfo error %v", err)
+		return false
+	}
+	dirURL = path.Clean(dirURL)
+	for _, line := range strings.Split(string(content), "\n") {
+		// the 5th field is the mount point, with spaces and the like escaped as octal
+		fields := strings.Fields(line)
+		if len(fields) > 4 && unescapeMountPoint(fields[4]) == dirURL {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeMountPoint undoes the octal escapes such as \040 of a mount point in mountinfo
+func unescapeMountPoint(mountPoint string) string {
+	var b strings.Builder
+	for i := 0; i < len(mountPoint); i++ {
+		if mountPoint[i] == '\\' && i+3 < len(mountPoint) {
+			if n, err := strconv.ParseUint(mountPoint[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(mountPoint[i])
 	}
+	return b.String()
 }
 
 // PathExists returns if the given path exists in the system
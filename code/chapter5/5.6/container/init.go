@@ -2,7 +2,6 @@ package container
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,42 +15,67 @@ import (
 	RunContainerInitProcess
 	The init function runs inside a container. Now the process which holds the container
 	has been created.
-	Use mount to mount proc fs, so that we can use ps, etc. to check process resources
+	Everything about the container comes from the InitConfig the parent sends through the pipe:
+	after pivot_root it mounts the filesystems (proc, so that we can use ps, etc. to check process resources),
+	sets the hostname and rlimits, and switches to the working dir, env and user of the user's command
 */
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
-		return fmt.Errorf("Run container get user command error, cmdArray is nil")
+	config, err := readInitConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Args) == 0 {
+		return fmt.Errorf("Run container get user command error, args is empty")
 	}
 
-	setupMount()
+	setupMount(config.Mounts)
 
+	if config.Hostname != "" {
+		if err := syscall.Sethostname([]byte(config.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error %v", config.Hostname, err)
+		}
+		log.Infof("set hostname to %s", config.Hostname)
+	}
+	if err := setupRlimits(config.Rlimits); err != nil {
+		return err
+	}
+	if config.Cwd != "" {
+		// the working dir may not be part of the image yet
+		if err := os.MkdirAll(config.Cwd, 0755); err != nil {
+			return fmt.Errorf("mkdir working dir %s error %v", config.Cwd, err)
+		}
+		if err := syscall.Chdir(config.Cwd); err != nil {
+			return fmt.Errorf("chdir %s error %v", config.Cwd, err)
+		}
+		log.Infof("changed cwd to %s", config.Cwd)
+	}
+	// the user is looked up in the container's /etc/passwd, so this has to come after pivot_root
+	if err := setupUser(config.User); err != nil {
+		return err
+	}
+
+	// exec.LookPath searches the PATH of our own env, so switch to the container's env first
+	os.Clearenv()
+	for _, kv := range config.Env {
+		kvPair := strings.SplitN(kv, "=", 2)
+		if len(kvPair) == 2 {
+			os.Setenv(kvPair[0], kvPair[1])
+		}
+	}
 	// use exec.LookPath to get abs path for commands
-	path, err := exec.LookPath(cmdArray[0])
+	path, err := exec.LookPath(config.Args[0])
 	if err != nil {
 		log.Errorf("exec loop path error %v", err)
 		return err
 	}
 	log.Infof("found path %s", path)
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, config.Args, config.Env); err != nil {
 		log.Errorf(err.Error())
 	}
 	return nil
 }
 
-func readUserCommand() []string {
-	// uintptr(3) is a file descriptor with index=3, which is the one end of the pipe passed in
-	pipe := os.NewFile(uintptr(3), "pipe")
-	msg, err := ioutil.ReadAll(pipe)
-	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
-	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
-}
-
-func setupMount() {
+func setupMount(mounts []Mount) {
 	// get cwd
 	pwd, err := os.Getwd()
 	if err != nil {
@@ -63,13 +87,17 @@ func setupMount() {
 		log.Errorf("%v", err)
 	}
 
-	// mount proc
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	log.Infof("mounted proc on /proc")
-
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
-	log.Infof("mounted tmpfs on /dev")
+	for _, m := range mounts {
+		if err := os.MkdirAll(m.Target, 0755); err != nil {
+			log.Errorf("mkdir %s error %v", m.Target, err)
+			continue
+		}
+		if err := syscall.Mount(m.Source, m.Target, m.Type, m.Flags, m.Data); err != nil {
+			log.Errorf("mount %s on %s error %v", m.Type, m.Target, err)
+			continue
+		}
+		log.Infof("mounted %s on %s", m.Type, m.Target)
+	}
 }
 
 func pivotRoot(root string) error {
@@ -0,0 +1,163 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// InitConfig is what the parent sends to the init process through the pipe,
+// it holds everything init needs to set up the container before running the user's command
+type InitConfig struct {
+	Args     []string `json:"args"`     // argv of the user's command
+	Env      []string `json:"env"`      // environment of the user's command in the form of KEY=VALUE
+	Cwd      string   `json:"cwd"`      // working dir of the user's command
+	User     string   `json:"user"`     // user[:group] running the user's command
+	Hostname string   `json:"hostname"` // hostname of the container's UTS namespace
+	Mounts   []Mount  `json:"mounts"`   // filesystems mounted after pivot_root
+	Rlimits  []Rlimit `json:"rlimits"`  // resource limits of the user's command
+}
+
+// InitConfig returns the config the init process of the container described by info is started with
+func (info *Info) InitConfig() *InitConfig {
+	return &InitConfig{
+		Args:     info.Args,
+		Env:      info.Env,
+		Cwd:      info.Cwd,
+		User:     info.User,
+		Hostname: info.Id,
+		Mounts:   DefaultMounts,
+		Rlimits:  info.Rlimits,
+	}
+}
+
+// Mount is a filesystem init mounts inside the container
+type Mount struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Type   string  `json:"type"`
+	Flags  uintptr `json:"flags"`
+	Data   string  `json:"data"`
+}
+
+// Rlimit is a resource limit set with setrlimit, Type is a name such as RLIMIT_NOFILE
+type Rlimit struct {
+	Type string `json:"type"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// some constants
+var (
+	// DefaultMounts are mounted in every container
+	// proc lets us use ps, etc. to check process resources
+	DefaultMounts = []Mount{
+		{
+			Source: "proc",
+			Target: "/proc",
+			Type:   "proc",
+			Flags:  syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV,
+		},
+		{
+			Source: "tmpfs",
+			Target: "/dev",
+			Type:   "tmpfs",
+			Flags:  syscall.MS_NOSUID | syscall.MS_STRICTATIME,
+			Data:   "mode=755",
+		},
+	}
+	// rlimInfinity is RLIM_INFINITY, the value of a limit that is not set
+	rlimInfinity = ^uint64(0)
+	rlimitTypes  = map[string]int{
+		"RLIMIT_AS":     syscall.RLIMIT_AS,
+		"RLIMIT_CORE":   syscall.RLIMIT_CORE,
+		"RLIMIT_CPU":    syscall.RLIMIT_CPU,
+		"RLIMIT_DATA":   syscall.RLIMIT_DATA,
+		"RLIMIT_FSIZE":  syscall.RLIMIT_FSIZE,
+		"RLIMIT_NOFILE": syscall.RLIMIT_NOFILE,
+		"RLIMIT_STACK":  syscall.RLIMIT_STACK,
+	}
+)
+
+// SendInitConfig writes config as json to writePipe and closes it, which tells init the message is complete
+func SendInitConfig(config *InitConfig, writePipe *os.File) error {
+	defer writePipe.Close()
+	jsonBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("json marshall init config error %v", err)
+	}
+	if _, err := writePipe.Write(jsonBytes); err != nil {
+		return fmt.Errorf("write init config error %v", err)
+	}
+	return nil
+}
+
+// readInitConfig reads the message of the parent from the pipe
+func readInitConfig() (*InitConfig, error) {
+	// uintptr(3) is a file descriptor with index=3, which is the one end of the pipe passed in
+	pipe := os.NewFile(uintptr(3), "pipe")
+	defer pipe.Close()
+	msg, err := ioutil.ReadAll(pipe)
+	if err != nil {
+		return nil, fmt.Errorf("init read pipe error %v", err)
+	}
+	var config InitConfig
+	if err := json.Unmarshal(msg, &config); err != nil {
+		return nil, fmt.Errorf("json unmarshall init config error %v", err)
+	}
+	return &config, nil
+}
+
+// ParseRlimit parses a resource limit given as name=soft[:hard] such as nofile=1024:2048, with the name of
+// an RLIMIT_ constant in lower case, the hard limit defaults to the soft one and -1 or unlimited lifts a limit
+func ParseRlimit(spec string) (Rlimit, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if len(parts) != 2 {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %s, expected name=soft[:hard]", spec)
+	}
+	rlimit := Rlimit{Type: "RLIMIT_" + strings.ToUpper(parts[0])}
+	if _, ok := rlimitTypes[rlimit.Type]; !ok {
+		return Rlimit{}, fmt.Errorf("unknown ulimit %s", parts[0])
+	}
+	values := strings.SplitN(parts[1], ":", 2)
+	var err error
+	if rlimit.Soft, err = parseRlimitValue(values[0]); err != nil {
+		return Rlimit{}, fmt.Errorf("invalid soft limit of ulimit %s", spec)
+	}
+	rlimit.Hard = rlimit.Soft
+	if len(values) == 2 {
+		if rlimit.Hard, err = parseRlimitValue(values[1]); err != nil {
+			return Rlimit{}, fmt.Errorf("invalid hard limit of ulimit %s", spec)
+		}
+	}
+	if rlimit.Soft > rlimit.Hard {
+		return Rlimit{}, fmt.Errorf("soft limit of ulimit %s is above its hard limit", spec)
+	}
+	return rlimit, nil
+}
+
+// parseRlimitValue parses a limit of ParseRlimit, -1 and unlimited become RLIM_INFINITY
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "-1" || value == "unlimited" {
+		return rlimInfinity, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// setupRlimits applies the resource limits in rlimits to the current process
+func setupRlimits(rlimits []Rlimit) error {
+	for _, rlimit := range rlimits {
+		resource, ok := rlimitTypes[rlimit.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit %s", rlimit.Type)
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}); err != nil {
+			return fmt.Errorf("setrlimit %s error %v", rlimit.Type, err)
+		}
+	}
+	return nil
+}
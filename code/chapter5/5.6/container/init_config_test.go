@@ -0,0 +1,42 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRlimit(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Rlimit
+		ok   bool
+	}{
+		{"nofile=1024:2048", Rlimit{"RLIMIT_NOFILE", 1024, 2048}, true},
+		{"nofile=1024", Rlimit{"RLIMIT_NOFILE", 1024, 1024}, true},
+		{"core=0:unlimited", Rlimit{"RLIMIT_CORE", 0, rlimInfinity}, true},
+		{"stack=-1", Rlimit{"RLIMIT_STACK", rlimInfinity, rlimInfinity}, true},
+		{"NOFILE=1", Rlimit{"RLIMIT_NOFILE", 1, 1}, true},
+		{"nofile=2048:1024", Rlimit{}, false},
+		{"nofile", Rlimit{}, false},
+		{"nofile=", Rlimit{}, false},
+		{"nofile=1:x", Rlimit{}, false},
+		{"nofile=-2", Rlimit{}, false},
+		{"bogus=1", Rlimit{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseRlimit(test.spec)
+		if test.ok != (err == nil) {
+			t.Errorf("ParseRlimit(%q) error %v, want ok %v", test.spec, err, test.ok)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseRlimit(%q) = %+v, want %+v", test.spec, got, test.want)
+		}
+	}
+
+	// the limits of the container reach its init process
+	info := &Info{Rlimits: []Rlimit{{"RLIMIT_NOFILE", 1024, 2048}}}
+	if got := info.InitConfig().Rlimits; !reflect.DeepEqual(got, info.Rlimits) {
+		t.Errorf("InitConfig().Rlimits = %+v, want %+v", got, info.Rlimits)
+	}
+}
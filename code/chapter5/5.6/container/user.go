@@ -0,0 +1,159 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// setupUser switches to user, given as user[:group] where both may be names or ids
+// names are looked up in /etc/passwd and /etc/group of the container
+func setupUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	userPart, groupPart := user, ""
+	if i := strings.Index(user, ":"); i >= 0 {
+		userPart, groupPart = user[:i], user[i+1:]
+	}
+
+	// each line of /etc/passwd looks like "name:password:uid:gid:gecos:home:shell"
+	uid, gid, err := lookupID("/etc/passwd", userPart)
+	if err != nil {
+		return fmt.Errorf("look up user %s error %v", userPart, err)
+	}
+	if groupPart != "" {
+		// each line of /etc/group looks like "name:password:gid:members"
+		if gid, _, err = lookupID("/etc/group", groupPart); err != nil {
+			return fmt.Errorf("look up group %s error %v", groupPart, err)
+		}
+	}
+
+	groups := []int{gid}
+	if groupPart == "" {
+		// without a group given, the user keeps the groups /etc/group lists it as a member of, as with docker and runc
+		if groups, err = supplementaryGroups("/etc/group", userName("/etc/passwd", userPart, uid), gid); err != nil {
+			return fmt.Errorf("look up groups of user %s error %v", userPart, err)
+		}
+	}
+
+	// the groups have to be dropped while we are still root
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups %v error %v", groups, err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d error %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d error %v", uid, err)
+	}
+	log.Infof("switched to uid %d gid %d", uid, gid)
+	return nil
+}
+
+// lookupID finds the entry called name in the passwd style file at fileURL and returns its
+// 3rd and 4th fields, numeric names without an entry are returned as is along with gid 0, as with docker
+func lookupID(fileURL string, name string) (int, int, error) {
+	id, idErr := strconv.Atoi(name)
+	f, err := os.Open(fileURL)
+	if err != nil {
+		if idErr == nil {
+			return id, 0, nil
+		}
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || (fields[0] != name && fields[2] != name) {
+			continue
+		}
+		first, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid id in %s", scanner.Text())
+		}
+		second := 0
+		if len(fields) > 3 {
+			if n, err := strconv.Atoi(fields[3]); err == nil {
+				second = n
+			}
+		}
+		return first, second, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if idErr == nil {
+		return id, 0, nil
+	}
+	return 0, 0, fmt.Errorf("%s not found in %s", name, fileURL)
+}
+
+// userName returns the name of the user given as user, which may be a name or the id uid, "" if it has none
+func userName(fileURL string, user string, uid int) string {
+	if _, err := strconv.Atoi(user); err != nil {
+		return user
+	}
+	f, err := os.Open(fileURL)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) >= 3 && fields[2] == strconv.Itoa(uid) {
+			return fields[0]
+		}
+	}
+	return ""
+}
+
+// supplementaryGroups returns gid along with the ids of the groups in the group file at fileURL
+// whose comma separated members, the 4th field, include name
+func supplementaryGroups(fileURL string, name string, gid int) ([]int, error) {
+	groups := []int{gid}
+	if name == "" {
+		return groups, nil
+	}
+	f, err := os.Open(fileURL)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return groups, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if strings.TrimSpace(member) != name {
+				continue
+			}
+			id, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid id in %s", scanner.Text())
+			}
+			if id != gid {
+				groups = append(groups, id)
+			}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,120 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestSupplementaryGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	groupURL := path.Join(dir, "group")
+	content := "root:x:0:\nwheel:x:10:root,alice\nusers:x:100:\ndocker:x:999:bob, alice\nalice:x:1000:\n"
+	if err := ioutil.WriteFile(groupURL, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		gid  int
+		want []int
+	}{
+		{"alice", 1000, []int{1000, 10, 999}},
+		{"bob", 100, []int{100, 999}},
+		{"root", 0, []int{0, 10}},
+		{"nobody", 65534, []int{65534}},
+		{"", 1000, []int{1000}},
+	}
+	for _, test := range tests {
+		got, err := supplementaryGroups(groupURL, test.name, test.gid)
+		if err != nil {
+			t.Errorf("supplementaryGroups(%q) error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("supplementaryGroups(%q, %d) = %v, want %v", test.name, test.gid, got, test.want)
+		}
+	}
+
+	// a group file the image does not have leaves just the primary group
+	got, err := supplementaryGroups(path.Join(dir, "missing"), "alice", 1000)
+	if err != nil || !reflect.DeepEqual(got, []int{1000}) {
+		t.Errorf("supplementaryGroups without a group file = %v, %v", got, err)
+	}
+}
+
+func TestUserName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwdURL := path.Join(dir, "passwd")
+	content := "root:x:0:0:root:/root:/bin/sh\nalice:x:1000:1000::/home/alice:/bin/sh\n"
+	if err := ioutil.WriteFile(passwdURL, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user string
+		uid  int
+		want string
+	}{
+		{"alice", 1000, "alice"},
+		{"1000", 1000, "alice"},
+		{"0", 0, "root"},
+		{"4242", 4242, ""},
+	}
+	for _, test := range tests {
+		if got := userName(passwdURL, test.user, test.uid); got != test.want {
+			t.Errorf("userName(%q) = %q, want %q", test.user, got, test.want)
+		}
+	}
+}
+
+func TestLookupID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwdURL := path.Join(dir, "passwd")
+	content := "root:x:0:0:root:/root:/bin/sh\nalice:x:1000:100::/home/alice:/bin/sh\n"
+	if err := ioutil.WriteFile(passwdURL, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fileURL string
+		name    string
+		uid     int
+		gid     int
+	}{
+		{passwdURL, "alice", 1000, 100},
+		{passwdURL, "1000", 1000, 100},
+		{passwdURL, "0", 0, 0},
+		// a uid without an entry, or without a passwd file, runs in group root
+		{passwdURL, "4242", 4242, 0},
+		{path.Join(dir, "missing"), "4242", 4242, 0},
+	}
+	for _, test := range tests {
+		uid, gid, err := lookupID(test.fileURL, test.name)
+		if err != nil || uid != test.uid || gid != test.gid {
+			t.Errorf("lookupID(%s, %q) = %d, %d, %v, want %d, %d", path.Base(test.fileURL), test.name, uid, gid, err, test.uid, test.gid)
+		}
+	}
+
+	// a gid without an entry in the group file is used as is
+	if gid, _, err := lookupID(path.Join(dir, "group"), "4242"); err != nil || gid != 4242 {
+		t.Errorf("lookupID of a gid without a group file = %d, %v, want 4242", gid, err)
+	}
+	if _, _, err := lookupID(passwdURL, "bob"); err == nil {
+		t.Errorf("lookupID of an unknown user succeeded")
+	}
+}
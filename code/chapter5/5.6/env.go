@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseEnv reads the variables of the files envFiles followed by envs
+// a variable given as KEY only takes its value from the host, and is left out if the host has none
+func parseEnv(envFiles []string, envs []string) ([]string, error) {
+	var envSlice []string
+	for _, envFile := range envFiles {
+		fileEnvs, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		envSlice = append(envSlice, fileEnvs...)
+	}
+	for _, kv := range envs {
+		if strings.HasPrefix(kv, "=") {
+			return nil, fmt.Errorf("invalid environment variable %s", kv)
+		}
+		if !strings.Contains(kv, "=") {
+			value, ok := os.LookupEnv(kv)
+			if !ok {
+				continue
+			}
+			kv = kv + "=" + value
+		}
+		envSlice = append(envSlice, kv)
+	}
+	return envSlice, nil
+}
+
+// readEnvFile reads the KEY=VALUE lines of envFile, skipping empty lines and # comments
+func readEnvFile(envFile string) ([]string, error) {
+	f, err := os.Open(envFile)
+	if err != nil {
+		return nil, fmt.Errorf("open env file %s error %v", envFile, err)
+	}
+	defer f.Close()
+
+	var envs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		envs = append(envs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read env file %s error %v", envFile, err)
+	}
+	// the lines follow the same rules as -e
+	return parseEnv(nil, envs)
+}
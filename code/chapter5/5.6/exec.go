@@ -19,11 +19,17 @@ const ENV_EXEC_CMD = "mydocker_cmd"
 
 func execContainer(containerName string, cmdArray []string) {
 	// get PID of the corresponding container with containerName
-	pid, err := getContainerPIDByName(containerName)
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("exec container: getContainerPIDbyName(%s) error %v", containerName, err)
+		log.Errorf("exec container: getContainerInfoByName(%s) error %v", containerName, err)
 		return
 	}
+	// the command would join frozen namespaces outside of the frozen cgroup
+	if containerInfo.Status == container.PAUSED {
+		log.Errorf("exec container: container %s is paused, unpause it first", containerName)
+		return
+	}
+	pid := containerInfo.Pid
 	// format the command to be space separated
 	cmdString := strings.Join(cmdArray, " ")
 	log.Infof("exec container: container PID is %s", pid)
@@ -35,14 +41,37 @@ func execContainer(containerName string, cmdArray []string) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdString)
+	// the command runs with the env of the container rather than the host's,
+	// plus the two variables telling the C code what to do
+	containerEnvs, err := getEnvsByPid(pid)
+	if err != nil {
+		log.Errorf("exec container: get env of container %s error %v", containerName, err)
+		return
+	}
+	cmd.Env = append(containerEnvs, ENV_EXEC_PID+"="+pid, ENV_EXEC_CMD+"="+cmdString)
 
 	if err := cmd.Run(); err != nil {
 		log.Errorf("exec container: exec %s command error %v", containerName, err)
 	}
 }
 
+// getEnvsByPid reads the environment of process pid from /proc/<pid>/environ
+func getEnvsByPid(pid string) ([]string, error) {
+	envFilePath := fmt.Sprintf("/proc/%s/environ", pid)
+	content, err := ioutil.ReadFile(envFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s error %v", envFilePath, err)
+	}
+	// the variables are separated by \0
+	var envs []string
+	for _, kv := range strings.Split(string(content), "\x00") {
+		if kv != "" {
+			envs = append(envs, kv)
+		}
+	}
+	return envs, nil
+}
+
 func getContainerPIDByName(containerName string) (string, error) {
 	// piece togeghet the container's location
 	configFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
@@ -0,0 +1,94 @@
+package image
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// dockerManifest is an entry of the manifest.json of a "docker save" archive
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// dockerConfig is the image config json of a "docker save" archive
+type dockerConfig struct {
+	Config Config `json:"config"`
+}
+
+// LoadDockerArchive loads the images of the "docker save" archive at archiveURL into the image store
+// an image is named after its repo tags, with the default ":latest" tag left out
+func LoadDockerArchive(archiveURL string) ([]*Image, error) {
+	tmpURL, err := ioutil.TempDir("", "mydocker-load-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir error %v", err)
+	}
+	defer os.RemoveAll(tmpURL)
+	if out, err := exec.Command("tar", "-xf", archiveURL, "-C", tmpURL).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("untar file %s error %v: %s", archiveURL, err, out)
+	}
+
+	var manifests []dockerManifest
+	if err := readJSON(path.Join(tmpURL, "manifest.json"), &manifests); err != nil {
+		return nil, err
+	}
+	var images []*Image
+	for _, manifest := range manifests {
+		if len(manifest.RepoTags) == 0 {
+			log.Warnf("skipping image %s of %s, it has no repo tag", manifest.Config, archiveURL)
+			continue
+		}
+		// the names come from the archive, check them before any layer is stored
+		for _, repoTag := range manifest.RepoTags {
+			if err := checkName(repoTag); err != nil {
+				return nil, err
+			}
+		}
+		var config dockerConfig
+		configURL, err := archiveFileURL(tmpURL, manifest.Config)
+		if err != nil {
+			return nil, err
+		}
+		if err := readJSON(configURL, &config); err != nil {
+			return nil, err
+		}
+		// the layers are listed from the bottom-most up, which is the order of the image store
+		var layers []string
+		for _, layer := range manifest.Layers {
+			layerURL, err := archiveFileURL(tmpURL, layer)
+			if err != nil {
+				return nil, err
+			}
+			digest, err := StoreLayer(layerURL, false)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, digest)
+		}
+		for _, repoTag := range manifest.RepoTags {
+			image := NewImage(strings.TrimSuffix(repoTag, ":latest"), layers, config.Config)
+			if err := image.Save(); err != nil {
+				return nil, err
+			}
+			log.Infof("loaded image %s with %d layers from %s", image.Name, len(layers), archiveURL)
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// archiveFileURL returns the path of name inside the archive unpacked at tmpURL
+func archiveFileURL(tmpURL string, name string) (string, error) {
+	fileURL := path.Join(tmpURL, name)
+	if !strings.HasPrefix(fileURL, path.Clean(tmpURL)+"/") {
+		return "", fmt.Errorf("invalid file name %s in archive", name)
+	}
+	return fileURL, nil
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// makeDockerArchive writes a "docker save" archive of one image with a single layer, tagged with repoTags
+func makeDockerArchive(t *testing.T, repoTags []string) string {
+	dir, err := ioutil.TempDir("", "docker-save")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	content := path.Join(dir, "content")
+	if err := os.Mkdir(content, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path.Join(content, "layer"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(content, "layer", "hello"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("tar", "-cf", path.Join(content, "layer.tar"), "-C", path.Join(content, "layer"), ".").CombinedOutput(); err != nil {
+		t.Fatalf("tar layer error %v: %s", err, out)
+	}
+	os.RemoveAll(path.Join(content, "layer"))
+	config, _ := json.Marshal(dockerConfig{Config: Config{Cmd: []string{"sh"}}})
+	manifest, _ := json.Marshal([]dockerManifest{{Config: "config.json", RepoTags: repoTags, Layers: []string{"layer.tar"}}})
+	for name, data := range map[string][]byte{"config.json": config, "manifest.json": manifest} {
+		if err := ioutil.WriteFile(path.Join(content, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archiveURL := path.Join(dir, "image.tar")
+	if out, err := exec.Command("tar", "-cf", archiveURL, "-C", content, ".").CombinedOutput(); err != nil {
+		t.Fatalf("tar archive error %v: %s", err, out)
+	}
+	return archiveURL
+}
+
+func TestLoadDockerArchive(t *testing.T) {
+	useTempStore(t)
+	images, err := LoadDockerArchive(makeDockerArchive(t, []string{"busybox:latest", "busybox:1.36"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default tag is left out of the name
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"busybox", "busybox:1.36"}) {
+		t.Errorf("loaded images %v, want busybox and busybox:1.36", names)
+	}
+	image, err := GetImage("busybox:1.36")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image.Layers) != 1 || !reflect.DeepEqual(image.Config.Cmd, []string{"sh"}) {
+		t.Errorf("image busybox:1.36 = %+v, want one layer and command sh", image)
+	}
+}
+
+func TestLoadDockerArchiveRejectsBadTag(t *testing.T) {
+	dir := useTempStore(t)
+	// the tag would take the metadata of the image out of the store
+	archiveURL := makeDockerArchive(t, []string{"busybox", "../../escaped"})
+	if _, err := LoadDockerArchive(archiveURL); err == nil {
+		t.Fatalf("LoadDockerArchive with tag ../../escaped succeeded")
+	}
+	if _, err := os.Stat(path.Join(dir, "escaped.json")); !os.IsNotExist(err) {
+		t.Errorf("LoadDockerArchive wrote %s", path.Join(dir, "escaped.json"))
+	}
+	// nothing of the archive is stored
+	blobs, _ := ioutil.ReadDir(path.Dir(fmt.Sprintf(BlobURL, "")))
+	if len(blobs) != 0 {
+		t.Errorf("LoadDockerArchive left %d blobs in the store", len(blobs))
+	}
+	if _, err := GetImage("busybox"); err == nil {
+		t.Errorf("image busybox of the rejected archive was saved")
+	}
+}
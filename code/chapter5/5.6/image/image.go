@@ -0,0 +1,159 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Image stores data about an image
+type Image struct {
+	Name         string   `json:"name"`         // image name
+	Layers       []string `json:"layers"`       // sha256 digests of the layers, from the bottom-most layer up
+	CreationTime string   `json:"creationTime"` // the creation time of the image
+	Config       Config   `json:"config"`       // the defaults of the containers started from the image
+}
+
+// Config stores the defaults of the containers started from an image
+// the json names match the ones of docker and OCI image configs
+type Config struct {
+	Entrypoint []string `json:"Entrypoint,omitempty"` // the executable run with the command as its arguments
+	Env        []string `json:"Env,omitempty"`        // environment variables in the form of KEY=VALUE
+	Cmd        []string `json:"Cmd,omitempty"`        // the command run when none is given
+	WorkingDir string   `json:"WorkingDir,omitempty"` // the cwd of the command
+	User       string   `json:"User,omitempty"`       // the user running the command, as user[:group]
+	StopSignal string   `json:"StopSignal,omitempty"` // the signal stop sends to the command, SIGTERM if not set
+}
+
+// some constants
+var (
+	BlobURL       = "/root/image/blobs/sha256/%s"
+	LayerURL      = "/root/image/%s/%s/"
+	RepositoryURL = "/root/image/repositories/%s.json"
+	LegacyTarURL  = "/root/%s.tar"
+)
+
+// nameRegexp matches image names such as busybox or library/busybox:1.36, path components of lower case letters
+// and digits split by single separators, and an optional tag
+var nameRegexp = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?$`)
+
+// NewImage creates an image named imageName out of layers
+func NewImage(imageName string, layers []string, config Config) *Image {
+	return &Image{
+		Name:         imageName,
+		Layers:       layers,
+		CreationTime: time.Now().Format("2006-01-02 15:04:05"),
+		Config:       config,
+	}
+}
+
+// GetImage reads the metadata of image imageName
+// images packaged as a single ${imageName}.tar are imported as a one layer image the first time they are used
+func GetImage(imageName string) (*Image, error) {
+	if err := checkName(imageName); err != nil {
+		return nil, err
+	}
+	repositoryFile := fmt.Sprintf(RepositoryURL, imageName)
+	content, err := ioutil.ReadFile(repositoryFile)
+	if os.IsNotExist(err) {
+		return importLegacyImage(imageName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read file %s error %v", repositoryFile, err)
+	}
+	var image Image
+	// unmarshall the json metadata into an object of the Image struct
+	if err := json.Unmarshal(content, &image); err != nil {
+		return nil, fmt.Errorf("json unmarshall %s error %v", repositoryFile, err)
+	}
+	return &image, nil
+}
+
+// Command returns the argv of a container of the image: the entrypoint followed by
+// args, or by the default command of the image if no args are given
+func (i *Image) Command(args []string) []string {
+	if len(args) == 0 {
+		args = i.Config.Cmd
+	}
+	return append(append([]string{}, i.Config.Entrypoint...), args...)
+}
+
+// Save writes the metadata of the image, replacing any image of the same name
+func (i *Image) Save() error {
+	if err := checkName(i.Name); err != nil {
+		return err
+	}
+	jsonBytes, err := json.Marshal(i)
+	if err != nil {
+		return fmt.Errorf("json marshall image %s error %v", i.Name, err)
+	}
+	repositoryFile := fmt.Sprintf(RepositoryURL, i.Name)
+	if err := os.MkdirAll(path.Dir(repositoryFile), 0700); err != nil {
+		return fmt.Errorf("mkdir %s error %v", path.Dir(repositoryFile), err)
+	}
+	if err := ioutil.WriteFile(repositoryFile, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("write to file %s error %v", repositoryFile, err)
+	}
+	log.Infof("saved image %s with %d layers to %s", i.Name, len(i.Layers), repositoryFile)
+	return nil
+}
+
+// LayerURLs unpacks the layers of the image for driver if needed and returns their directories,
+// top-most layer first, which is the order the storage drivers stack them in
+func (i *Image) LayerURLs(driver storage.Driver) ([]string, error) {
+	var layerURLs []string
+	for _, digest := range i.Layers {
+		layerURL, err := UnpackLayer(digest, driver)
+		if err != nil {
+			return nil, err
+		}
+		layerURLs = append([]string{layerURL}, layerURLs...)
+	}
+	return layerURLs, nil
+}
+
+// importLegacyImage stores ${imageName}.tar as the only layer of image imageName
+func importLegacyImage(imageName string) (*Image, error) {
+	imageTarURL := fmt.Sprintf(LegacyTarURL, imageName)
+	if exist, _ := pathExists(imageTarURL); !exist {
+		return nil, fmt.Errorf("image %s not found", imageName)
+	}
+	digest, err := StoreLayer(imageTarURL, false)
+	if err != nil {
+		return nil, err
+	}
+	image := NewImage(imageName, []string{digest}, Config{})
+	if err := image.Save(); err != nil {
+		return nil, err
+	}
+	log.Infof("imported %s as image %s", imageTarURL, imageName)
+	return image, nil
+}
+
+// checkName checks that imageName is a valid image name, the name is a path in the image store and must stay in it
+func checkName(imageName string) error {
+	if !nameRegexp.MatchString(imageName) || strings.Contains(imageName, "..") {
+		return fmt.Errorf("invalid image name %q", imageName)
+	}
+	return nil
+}
+
+// pathExists returns if the given path exists in the system
+func pathExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,45 @@
+package image
+
+import "testing"
+
+func TestCheckName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"busybox", false},
+		{"busybox:1.36", false},
+		{"busybox:latest", false},
+		{"library/busybox", false},
+		{"my-app_v2.1/web:Release_2", false},
+		{"", true},
+		{"Busybox", true},
+		{"/busybox", true},
+		{"busybox/", true},
+		{"../../etc/cron.d/x", true},
+		{"library/../busybox", true},
+		{"library//busybox", true},
+		{"busy..box", true},
+		{"busybox:1..2", true},
+		{"busybox:", true},
+		{"busybox:-1", true},
+		{"busybox:a/b", true},
+		{"-busybox", true},
+		{"busybox-", true},
+	}
+	for _, test := range tests {
+		if err := checkName(test.name); (err != nil) != test.wantErr {
+			t.Errorf("checkName(%q) error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestSaveRejectsBadName(t *testing.T) {
+	useTempStore(t)
+	if err := NewImage("../../escaped", nil, Config{}).Save(); err == nil {
+		t.Errorf("Save of image ../../escaped succeeded")
+	}
+	if _, err := GetImage("../../escaped"); err == nil {
+		t.Errorf("GetImage of image ../../escaped succeeded")
+	}
+}
@@ -0,0 +1,215 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateLayer packages the write layer at dirURL into a new layer and returns its digest
+// the whiteouts of driver are written as OCI whiteouts, so the layer can be used with any driver
+func CreateLayer(dirURL string, driver storage.Driver) (string, error) {
+	// write the tarball next to the blobs, so that storing it is a rename
+	blobDirURL := path.Dir(fmt.Sprintf(BlobURL, ""))
+	if err := os.MkdirAll(blobDirURL, 0700); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", blobDirURL, err)
+	}
+	tmpFile, err := ioutil.TempFile(blobDirURL, "layer-")
+	if err != nil {
+		return "", fmt.Errorf("create temp layer file error %v", err)
+	}
+	gzipWriter := gzip.NewWriter(tmpFile)
+	err = tarLayer(dirURL, gzipWriter, driver)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("tar folder %s error %v", dirURL, err)
+	}
+	return StoreLayer(tmpFile.Name(), true)
+}
+
+// StoreLayer adds the layer tarball at tarURL to the blob store and returns its digest
+// layers already in the store are kept once, if move is set tarURL is consumed
+func StoreLayer(tarURL string, move bool) (string, error) {
+	digest, err := fileDigest(tarURL)
+	if err != nil {
+		return "", err
+	}
+	blobURL := fmt.Sprintf(BlobURL, digest)
+	if exist, _ := pathExists(blobURL); exist {
+		log.Infof("layer %s already exists, skipping", digest)
+		if move {
+			os.Remove(tarURL)
+		}
+		return digest, nil
+	}
+	if err := os.MkdirAll(path.Dir(blobURL), 0700); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", path.Dir(blobURL), err)
+	}
+	if move {
+		err = os.Rename(tarURL, blobURL)
+	} else {
+		err = copyFile(tarURL, blobURL)
+	}
+	if err != nil {
+		return "", fmt.Errorf("store layer %s error %v", tarURL, err)
+	}
+	log.Infof("stored layer %s at %s", digest, blobURL)
+	return digest, nil
+}
+
+// UnpackLayer untars the layer digest into its own directory for driver and returns it
+// a layer is unpacked only once per driver and then shared by every image and container using it
+func UnpackLayer(digest string, driver storage.Driver) (string, error) {
+	layerURL := fmt.Sprintf(LayerURL, driver.Name(), digest)
+	if exist, _ := pathExists(layerURL); exist {
+		return layerURL, nil
+	}
+	blobURL := fmt.Sprintf(BlobURL, digest)
+	// untar into a temporary dir first, so a failed untar never looks like an unpacked layer
+	layerDirURL := path.Dir(path.Clean(layerURL))
+	if err := os.MkdirAll(layerDirURL, 0700); err != nil {
+		return "", fmt.Errorf("mkdir %s error %v", layerDirURL, err)
+	}
+	tmpURL, err := ioutil.TempDir(layerDirURL, "unpack-")
+	if err != nil {
+		return "", fmt.Errorf("create temp layer dir error %v", err)
+	}
+	if out, err := exec.Command("tar", "-xf", blobURL, "-C", tmpURL).CombinedOutput(); err != nil {
+		os.RemoveAll(tmpURL)
+		return "", fmt.Errorf("untar file %s error %v: %s", blobURL, err, out)
+	}
+	// the layer holds OCI whiteouts, which the driver has to see in its own form
+	if err := driver.ImportWhiteouts(tmpURL); err != nil {
+		os.RemoveAll(tmpURL)
+		return "", err
+	}
+	os.Chmod(tmpURL, 0755)
+	if err := os.Rename(tmpURL, layerURL); err != nil {
+		os.RemoveAll(tmpURL)
+		return "", fmt.Errorf("rename %s to %s error %v", tmpURL, layerURL, err)
+	}
+	log.Infof("untared layer %s to %s", digest, layerURL)
+	return layerURL, nil
+}
+
+// tarLayer writes the files under dirURL to w as an uncompressed layer tarball
+func tarLayer(dirURL string, w io.Writer, driver storage.Driver) error {
+	tarWriter := tar.NewWriter(w)
+	err := filepath.Walk(dirURL, func(fileURL string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dirURL, fileURL)
+		if err != nil || relPath == "." {
+			return err
+		}
+		// the aufs housekeeping files (.wh..wh.aufs, .wh..wh.plnk, ...) are not part of the layer
+		if strings.HasPrefix(info.Name(), storage.WhiteoutPrefix+storage.WhiteoutPrefix) && info.Name() != storage.WhiteoutOpaque {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// a deleted file becomes an empty .wh.<name> file
+		if driver.IsWhiteout(fileURL, info) {
+			return writeWhiteout(tarWriter, filepath.Join(filepath.Dir(relPath), storage.WhiteoutPrefix+info.Name()))
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(fileURL); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = relPath
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			file, err := os.Open(fileURL)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tarWriter, file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+		}
+		// a dir hiding the layers below it gets a .wh..wh..opq file
+		if info.IsDir() && driver.IsOpaque(fileURL) {
+			return writeWhiteout(tarWriter, filepath.Join(relPath, storage.WhiteoutOpaque))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// writeWhiteout adds an empty regular file called name to the tarball
+func writeWhiteout(tarWriter *tar.Writer, name string) error {
+	return tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+	})
+}
+
+// fileDigest returns the hex encoded sha256 of the file at fileURL
+func fileDigest(fileURL string) (string, error) {
+	file, err := os.Open(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("open file %s error %v", fileURL, err)
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read file %s error %v", fileURL, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func copyFile(srcURL string, dstURL string) error {
+	src, err := os.Open(srcURL)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(dstURL)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dstURL)
+		return err
+	}
+	return dst.Close()
+}
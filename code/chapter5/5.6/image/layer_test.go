@@ -0,0 +1,299 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+	"testing"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
+)
+
+// useTempStore points the image store at a temp dir for the length of a test
+func useTempStore(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "image-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobURL, layerURL, repositoryURL, legacyTarURL := BlobURL, LayerURL, RepositoryURL, LegacyTarURL
+	BlobURL = path.Join(dir, "blobs", "sha256") + "/%s"
+	LayerURL = path.Join(dir, "%s", "%s") + "/"
+	RepositoryURL = path.Join(dir, "repositories") + "/%s.json"
+	LegacyTarURL = path.Join(dir, "%s.tar")
+	t.Cleanup(func() {
+		BlobURL, LayerURL, RepositoryURL, LegacyTarURL = blobURL, layerURL, repositoryURL, legacyTarURL
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// tarEntries lists the names of the entries of the gzipped layer tarball at blobURL
+func tarEntries(t *testing.T, blobURL string) []string {
+	file, err := os.Open(blobURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// makeOverlayWriteLayer makes a write layer as overlay leaves it: a file, a deleted file and an opaque dir
+// it skips the test where whiteouts cannot be made, they need root and trusted xattrs
+func makeOverlayWriteLayer(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "write-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(path.Join(dir, "kept"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path.Join(dir, "opaque"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mknod(path.Join(dir, "deleted"), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("mknod whiteout error %v", err)
+	}
+	if err := syscall.Setxattr(path.Join(dir, "opaque"), "trusted.overlay.opaque", []byte("y"), 0); err != nil {
+		t.Skipf("set opaque xattr error %v", err)
+	}
+	return dir
+}
+
+func TestCreateLayerOverlayWhiteouts(t *testing.T) {
+	useTempStore(t)
+	writeLayer := makeOverlayWriteLayer(t)
+	driver := &storage.OverlayDriver{}
+
+	digest, err := CreateLayer(writeLayer, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobURL := fmt.Sprintf(BlobURL, digest)
+	content, err := ioutil.ReadFile(blobURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// layers are content addressed by the sha256 of their blob
+	hash := sha256.Sum256(content)
+	if got := hex.EncodeToString(hash[:]); got != digest {
+		t.Errorf("digest of layer = %s, want %s", digest, got)
+	}
+
+	// the overlay whiteouts are stored in their OCI form
+	want := []string{".wh.deleted", "kept", "opaque/", "opaque/.wh..wh..opq"}
+	if got := tarEntries(t, blobURL); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("entries of layer = %v, want %v", got, want)
+	}
+
+	// and turned back into overlay whiteouts when unpacked
+	layerURL, err := UnpackLayer(digest, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOverlayLayer(t, layerURL)
+
+	// a layer made by aufs may hold its housekeeping files, which are no whiteouts
+	tarURL := writeLayerTar(t, []tar.Header{
+		{Name: ".wh..wh.plnk/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: ".wh..wh.plnk/1234.5678", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: ".wh..wh.aufs", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: ".wh.deleted", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "opaque/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "kept", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+	})
+	aufsDigest, err := StoreLayer(tarURL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layerURL, err = UnpackLayer(aufsDigest, driver); err != nil {
+		t.Fatal(err)
+	}
+	checkOverlayLayer(t, layerURL)
+}
+
+// writeLayerTar writes a gzipped layer tarball with the entries of headers, regular files of size 4 hold "kept"
+func writeLayerTar(t *testing.T, headers []tar.Header) string {
+	file, err := ioutil.TempFile("", "layer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(file.Name()) })
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for i := range headers {
+		if err := tarWriter.WriteHeader(&headers[i]); err != nil {
+			t.Fatal(err)
+		}
+		if headers[i].Size == 4 {
+			if _, err := tarWriter.Write([]byte("kept")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+// checkOverlayLayer checks that the layer made by makeOverlayWriteLayer unpacked at layerURL has its overlay whiteouts back
+func checkOverlayLayer(t *testing.T, layerURL string) {
+	driver := &storage.OverlayDriver{}
+	info, err := os.Lstat(path.Join(layerURL, "deleted"))
+	if err != nil || !driver.IsWhiteout(path.Join(layerURL, "deleted"), info) {
+		t.Errorf("deleted is not an overlay whiteout in %s: %v", layerURL, err)
+	}
+	if !driver.IsOpaque(path.Join(layerURL, "opaque")) {
+		t.Errorf("opaque is not an opaque dir in %s", layerURL)
+	}
+	for _, name := range []string{".wh.deleted", "opaque/.wh..wh..opq"} {
+		if _, err := os.Lstat(path.Join(layerURL, name)); !os.IsNotExist(err) {
+			t.Errorf("%s is left in %s", name, layerURL)
+		}
+	}
+	if content, err := ioutil.ReadFile(path.Join(layerURL, "kept")); err != nil || string(content) != "kept" {
+		t.Errorf("kept = %q, %v", content, err)
+	}
+}
+
+func TestCreateLayerAufsWhiteouts(t *testing.T) {
+	useTempStore(t)
+	writeLayer, err := ioutil.TempDir("", "write-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(writeLayer)
+	files := []string{"kept", ".wh.deleted", "opaque/.wh..wh..opq", ".wh..wh.plnk/1234", ".wh..wh.aufs"}
+	for _, name := range files {
+		if err := os.MkdirAll(path.Join(writeLayer, path.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(writeLayer, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driver := &storage.AufsDriver{}
+
+	digest, err := CreateLayer(writeLayer, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the aufs whiteouts are OCI whiteouts already, its housekeeping files are left out
+	want := []string{".wh.deleted", "kept", "opaque/", "opaque/.wh..wh..opq"}
+	if got := tarEntries(t, fmt.Sprintf(BlobURL, digest)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("entries of layer = %v, want %v", got, want)
+	}
+
+	layerURL, err := UnpackLayer(digest, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{".wh.deleted", "opaque/.wh..wh..opq", "kept"} {
+		if _, err := os.Stat(path.Join(layerURL, name)); err != nil {
+			t.Errorf("%s is missing from %s: %v", name, layerURL, err)
+		}
+	}
+}
+
+func TestCreateLayer(t *testing.T) {
+	useTempStore(t)
+	dir, err := ioutil.TempDir("", "write-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(path.Join(dir, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the housekeeping files aufs keeps at the top of a write layer are left out
+	if err := os.Mkdir(path.Join(dir, ".wh..wh.plnk"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	driver := &storage.AufsDriver{}
+	digest, err := CreateLayer(dir, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the digest is the sha256 of the stored tarball
+	content, err := ioutil.ReadFile(fmt.Sprintf(BlobURL, digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != digest {
+		t.Errorf("layer digest %s does not match its content", digest)
+	}
+
+	layerURL, err := UnpackLayer(digest, driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path.Join(layerURL, "file")); err != nil || string(data) != "data" {
+		t.Errorf("unpacked file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(path.Join(layerURL, ".wh..wh.plnk")); !os.IsNotExist(err) {
+		t.Errorf("the aufs housekeeping dir made it into the layer")
+	}
+}
+
+func TestStoreLayerDeduplicates(t *testing.T) {
+	useTempStore(t)
+	dir, err := ioutil.TempDir("", "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tarURL := path.Join(dir, "layer.tar")
+	if err := ioutil.WriteFile(tarURL, []byte("not really a tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := StoreLayer(tarURL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tarURL); err != nil {
+		t.Errorf("StoreLayer without move removed %s", tarURL)
+	}
+	second, err := StoreLayer(tarURL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("the same layer got digests %s and %s", first, second)
+	}
+	if _, err := os.Stat(tarURL); !os.IsNotExist(err) {
+		t.Errorf("StoreLayer with move kept %s", tarURL)
+	}
+}
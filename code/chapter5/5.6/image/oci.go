@@ -0,0 +1,318 @@
+package image
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ociDescriptor points to a blob of an OCI image layout
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+}
+
+// ociPlatform is the platform an image of an image index is built for
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// ociIndex is the content of index.json, or of an image index blob
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest lists the config and the layers of an image
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociConfig is the image config blob
+type ociConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Config       Config `json:"config"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// some constants
+var (
+	ociLayoutFile        = "oci-layout"
+	ociIndexFile         = "index.json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar"
+	ociLayerGzipType     = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ImportOCI imports the images of the OCI image layout at layoutURL into the image store
+// the images are named after their org.opencontainers.image.ref.name annotation,
+// unless imageName is given, in which case only the first image is imported under that name
+func ImportOCI(layoutURL string, imageName string) ([]*Image, error) {
+	var index ociIndex
+	if err := readJSON(path.Join(layoutURL, ociIndexFile), &index); err != nil {
+		return nil, err
+	}
+	manifests, err := resolveManifests(layoutURL, index.Manifests)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no image found in %s", layoutURL)
+	}
+	if imageName != "" {
+		manifests = manifests[:1]
+	}
+
+	var images []*Image
+	for _, descriptor := range manifests {
+		name := imageName
+		if name == "" {
+			name = descriptor.Annotations[ociRefNameAnnotation]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("image %s in %s has no name, please give one", descriptor.Digest, layoutURL)
+		}
+		// the name may come from the layout, check it before any layer is stored
+		if err := checkName(name); err != nil {
+			return nil, err
+		}
+		image, err := importOCIManifest(layoutURL, descriptor, name)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// ExportOCI writes image imageName to dirURL as an OCI image layout
+func ExportOCI(imageName string, dirURL string) error {
+	image, err := GetImage(imageName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(dirURL, "blobs", "sha256"), 0755); err != nil {
+		return fmt.Errorf("mkdir %s error %v", dirURL, err)
+	}
+	if err := ioutil.WriteFile(path.Join(dirURL, ociLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		return fmt.Errorf("write %s error %v", ociLayoutFile, err)
+	}
+
+	config := ociConfig{
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config:       image.Config,
+	}
+	config.RootFS.Type = "layers"
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+	}
+	for _, digest := range image.Layers {
+		blobURL := fmt.Sprintf(BlobURL, digest)
+		if err := copyFile(blobURL, path.Join(dirURL, "blobs", "sha256", digest)); err != nil {
+			return fmt.Errorf("copy layer %s error %v", digest, err)
+		}
+		// the config refers to the layers by the digest of their uncompressed tarball
+		descriptor, diffID, err := layerDescriptor(blobURL, digest)
+		if err != nil {
+			return err
+		}
+		manifest.Layers = append(manifest.Layers, descriptor)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	}
+
+	if manifest.Config, err = writeJSONBlob(dirURL, ociConfigMediaType, config); err != nil {
+		return err
+	}
+	manifestDescriptor, err := writeJSONBlob(dirURL, ociManifestMediaType, manifest)
+	if err != nil {
+		return err
+	}
+	manifestDescriptor.Annotations = map[string]string{ociRefNameAnnotation: imageName}
+	index := ociIndex{
+		SchemaVersion: 2,
+		Manifests:     []ociDescriptor{manifestDescriptor},
+	}
+	jsonBytes, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("json marshall index error %v", err)
+	}
+	if err := ioutil.WriteFile(path.Join(dirURL, ociIndexFile), jsonBytes, 0644); err != nil {
+		return fmt.Errorf("write %s error %v", ociIndexFile, err)
+	}
+	log.Infof("exported image %s with %d layers to %s", imageName, len(image.Layers), dirURL)
+	return nil
+}
+
+// resolveManifests replaces the image indexes among descriptors with the manifest for this platform
+func resolveManifests(layoutURL string, descriptors []ociDescriptor) ([]ociDescriptor, error) {
+	var manifests []ociDescriptor
+	for _, descriptor := range descriptors {
+		if descriptor.MediaType != ociIndexMediaType {
+			manifests = append(manifests, descriptor)
+			continue
+		}
+		var index ociIndex
+		if err := readJSONBlob(layoutURL, descriptor.Digest, &index); err != nil {
+			return nil, err
+		}
+		for _, platformDescriptor := range index.Manifests {
+			platform := platformDescriptor.Platform
+			if platform == nil || (platform.OS == "linux" && platform.Architecture == runtime.GOARCH) {
+				// the image keeps the name given to the index
+				if platformDescriptor.Annotations[ociRefNameAnnotation] == "" {
+					platformDescriptor.Annotations = descriptor.Annotations
+				}
+				manifests = append(manifests, platformDescriptor)
+				break
+			}
+		}
+	}
+	return manifests, nil
+}
+
+// importOCIManifest stores the layers of the image described by descriptor and saves it as imageName
+func importOCIManifest(layoutURL string, descriptor ociDescriptor, imageName string) (*Image, error) {
+	var manifest ociManifest
+	if err := readJSONBlob(layoutURL, descriptor.Digest, &manifest); err != nil {
+		return nil, err
+	}
+	// the config holds the defaults of the containers started from the image
+	var config ociConfig
+	if err := readJSONBlob(layoutURL, manifest.Config.Digest, &config); err != nil {
+		return nil, err
+	}
+	var layers []string
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != ociLayerMediaType && layer.MediaType != ociLayerGzipType && !strings.HasPrefix(layer.MediaType, "application/vnd.docker.image.rootfs.diff.tar") {
+			return nil, fmt.Errorf("layer %s has unsupported media type %s", layer.Digest, layer.MediaType)
+		}
+		blobURL, err := ociBlobURL(layoutURL, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		// the store names blobs by their sha256, which must be the digest the manifest expects,
+		// a blob that is not is left out of the store
+		digest, err := fileDigest(blobURL)
+		if err != nil {
+			return nil, err
+		}
+		if "sha256:"+digest != layer.Digest {
+			return nil, fmt.Errorf("layer %s has digest sha256:%s", layer.Digest, digest)
+		}
+		if _, err := StoreLayer(blobURL, false); err != nil {
+			return nil, err
+		}
+		layers = append(layers, digest)
+	}
+	image := NewImage(imageName, layers, config.Config)
+	if err := image.Save(); err != nil {
+		return nil, err
+	}
+	log.Infof("imported image %s with %d layers from %s", imageName, len(layers), layoutURL)
+	return image, nil
+}
+
+// layerDescriptor describes the layer blob at blobURL and returns the digest of its uncompressed content
+func layerDescriptor(blobURL string, digest string) (ociDescriptor, string, error) {
+	file, err := os.Open(blobURL)
+	if err != nil {
+		return ociDescriptor{}, "", fmt.Errorf("open file %s error %v", blobURL, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return ociDescriptor{}, "", fmt.Errorf("stat file %s error %v", blobURL, err)
+	}
+	descriptor := ociDescriptor{
+		MediaType: ociLayerMediaType,
+		Digest:    "sha256:" + digest,
+		Size:      info.Size(),
+	}
+	// layers imported from elsewhere may be plain tarballs, whose diff id is their digest
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return descriptor, descriptor.Digest, nil
+	}
+	defer gzipReader.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, gzipReader); err != nil {
+		return ociDescriptor{}, "", fmt.Errorf("read layer %s error %v", digest, err)
+	}
+	descriptor.MediaType = ociLayerGzipType
+	return descriptor, "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ociBlobURL returns the path of the blob digest inside the layout at layoutURL
+func ociBlobURL(layoutURL string, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || strings.Contains(parts[1], "/") {
+		return "", fmt.Errorf("unsupported digest %s", digest)
+	}
+	return path.Join(layoutURL, "blobs", parts[0], parts[1]), nil
+}
+
+// readJSONBlob unmarshalls the json blob digest of the layout at layoutURL into v
+func readJSONBlob(layoutURL string, digest string, v interface{}) error {
+	blobURL, err := ociBlobURL(layoutURL, digest)
+	if err != nil {
+		return err
+	}
+	return readJSON(blobURL, v)
+}
+
+// writeJSONBlob writes v as a json blob into the layout at layoutURL
+func writeJSONBlob(layoutURL string, mediaType string, v interface{}) (ociDescriptor, error) {
+	jsonBytes, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, fmt.Errorf("json marshall %s error %v", mediaType, err)
+	}
+	hash := sha256.Sum256(jsonBytes)
+	digest := hex.EncodeToString(hash[:])
+	if err := ioutil.WriteFile(path.Join(layoutURL, "blobs", "sha256", digest), jsonBytes, 0644); err != nil {
+		return ociDescriptor{}, fmt.Errorf("write blob %s error %v", digest, err)
+	}
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    "sha256:" + digest,
+		Size:      int64(len(jsonBytes)),
+	}, nil
+}
+
+// readJSON unmarshalls the json file at fileURL into v
+func readJSON(fileURL string, v interface{}) error {
+	content, err := ioutil.ReadFile(fileURL)
+	if err != nil {
+		return fmt.Errorf("read file %s error %v", fileURL, err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("json unmarshall %s error %v", fileURL, err)
+	}
+	return nil
+}
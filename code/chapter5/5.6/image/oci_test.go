@@ -0,0 +1,174 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
+)
+
+// makeWhiteoutLayer stores a layer holding a file, a whiteout and an opaque dir, and returns its digest
+func makeWhiteoutLayer(t *testing.T) string {
+	writeLayer, err := ioutil.TempDir("", "write-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(writeLayer)
+	for _, name := range []string{".wh.deleted", "opaque/.wh..wh..opq"} {
+		if err := os.MkdirAll(path.Join(writeLayer, path.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(writeLayer, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(writeLayer, "kept"), []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := CreateLayer(writeLayer, &storage.AufsDriver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func TestOCIRoundTrip(t *testing.T) {
+	useTempStore(t)
+	digest := makeWhiteoutLayer(t)
+	config := Config{
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Env:        []string{"PATH=/bin"},
+		Cmd:        []string{"echo hello"},
+		WorkingDir: "/tmp",
+		User:       "nobody",
+		StopSignal: "SIGINT",
+	}
+	image := NewImage("busybox", []string{digest}, config)
+	if err := image.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	layoutURL, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(layoutURL)
+	if err := ExportOCI("busybox", layoutURL); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{ociLayoutFile, ociIndexFile, path.Join("blobs", "sha256", digest)} {
+		if _, err := os.Stat(path.Join(layoutURL, name)); err != nil {
+			t.Errorf("%s is missing from the layout: %v", name, err)
+		}
+	}
+
+	// import into an empty store, once under the exported name and once under a new one
+	useTempStore(t)
+	for _, imageName := range []string{"", "copy"} {
+		images, err := ImportOCI(layoutURL, imageName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantName := imageName
+		if wantName == "" {
+			wantName = "busybox"
+		}
+		if len(images) != 1 || images[0].Name != wantName {
+			t.Fatalf("ImportOCI(%q) = %v, want image %s", imageName, images, wantName)
+		}
+		saved, err := GetImage(wantName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(saved.Layers, image.Layers) {
+			t.Errorf("layers of %s = %v, want %v", wantName, saved.Layers, image.Layers)
+		}
+		if !reflect.DeepEqual(saved.Config, image.Config) {
+			t.Errorf("config of %s = %+v, want %+v", wantName, saved.Config, image.Config)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf(BlobURL, digest)); err != nil {
+		t.Fatalf("layer %s is not in the store: %v", digest, err)
+	}
+
+	// the whiteouts of the imported layer still turn into overlay whiteouts
+	if os.Geteuid() != 0 {
+		t.Skip("overlay whiteouts need root")
+	}
+	layerURL, err := UnpackLayer(digest, &storage.OverlayDriver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOverlayLayer(t, layerURL)
+}
+
+func TestImportOCIRejectsBadDigest(t *testing.T) {
+	useTempStore(t)
+	digest := makeWhiteoutLayer(t)
+	if err := NewImage("busybox", []string{digest}, Config{}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	layoutURL, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(layoutURL)
+	if err := ExportOCI("busybox", layoutURL); err != nil {
+		t.Fatal(err)
+	}
+	// a layer blob that does not match its digest must not be imported
+	blobURL := path.Join(layoutURL, "blobs", "sha256", digest)
+	if err := ioutil.WriteFile(blobURL, []byte("corrupted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	useTempStore(t)
+	if _, err := ImportOCI(layoutURL, ""); err == nil {
+		t.Errorf("ImportOCI of a corrupted layer succeeded")
+	}
+	blobs, _ := ioutil.ReadDir(path.Dir(fmt.Sprintf(BlobURL, "")))
+	if len(blobs) != 0 {
+		t.Errorf("ImportOCI of a corrupted layer left %d blobs in the store", len(blobs))
+	}
+}
+
+func TestImportOCIRejectsBadName(t *testing.T) {
+	dir := useTempStore(t)
+	digest := makeWhiteoutLayer(t)
+	if err := NewImage("busybox", []string{digest}, Config{}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	layoutURL, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(layoutURL)
+	if err := ExportOCI("busybox", layoutURL); err != nil {
+		t.Fatal(err)
+	}
+	var index ociIndex
+	if err := readJSON(path.Join(layoutURL, ociIndexFile), &index); err != nil {
+		t.Fatal(err)
+	}
+	// the name in the layout would take the metadata of the image out of the store
+	index.Manifests[0].Annotations[ociRefNameAnnotation] = "../../escaped"
+	jsonBytes, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(layoutURL, ociIndexFile), jsonBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, imageName := range []string{"", "../escaped", "/tmp/escaped"} {
+		if _, err := ImportOCI(layoutURL, imageName); err == nil {
+			t.Errorf("ImportOCI with name %q succeeded", imageName)
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "escaped.json")); !os.IsNotExist(err) {
+		t.Errorf("ImportOCI wrote %s", path.Join(dir, "escaped.json"))
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// inspectContainer prints the recorded info of a container as indented json,
+// including its resource limits and whether the OOM killer struck it
+func inspectContainer(containerName string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(containerInfo, "", "    ")
+	if err != nil {
+		return fmt.Errorf("json marshal container %s's info error %v", containerName, err)
+	}
+	fmt.Println(string(content))
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+	"syscall"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// killContainer sends signal to the init process of the container,
+// or with all to every process of the container in its cgroup
+func killContainer(containerName string, signal syscall.Signal, all bool) {
+	// get the containrt's PID
+	pid, err := getContainerPIDByName(containerName)
+	if err != nil {
+		log.Errorf("get container pid by name %s error %v", containerName, err)
+		return
+	}
+	intPid, err := strconv.Atoi(pid)
+	if err != nil {
+		log.Errorf("container %s is not running", containerName)
+		return
+	}
+	pids := []int{intPid}
+	if all {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+			log.Errorf("get container %s's info error %v", containerName, err)
+			return
+		}
+		if pids, err = containerCgroupManager(containerInfo).GetPids(); err != nil {
+			log.Errorf("get processes of container %s error %v", containerName, err)
+			return
+		}
+	}
+	for _, p := range pids {
+		if err := syscall.Kill(p, signal); err != nil && err != syscall.ESRCH {
+			log.Errorf("send %v to process %d of container %s error %v", signal, p, containerName, err)
+			continue
+		}
+		log.Infof("sent %v to process %d of container %s", signal, p, containerName)
+	}
+	// other signals wait for the container to be unpaused, but SIGKILL is meant to take effect now
+	if signal == syscall.SIGKILL {
+		_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+			if containerInfo.Status == container.PAUSED {
+				return thawContainer(containerInfo)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Errorf("unpause container %s error %v", containerName, err)
+		}
+	}
+}
@@ -39,13 +39,14 @@ func ListContainers() {
 	// tabwriter calls the text/tabwriter library to print space aligned tables
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	// tab columns in the console
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tRESTARTS\tCOMMAND\tCREATED\n")
 	for _, item := range containerInfos {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t\n",
 			item.Id,
 			item.Name,
 			item.Pid,
-			item.Status,
+			statusString(item),
+			item.RestartCount,
 			item.Command,
 			item.CreationTime)
 	}
@@ -56,6 +57,18 @@ func ListContainers() {
 	}
 }
 
+// statusString shows the exit code next to the status of exited containers, as in "Exited (137)",
+// and whether the OOM killer struck, as in "Exited (137) OOMKilled"
+func statusString(info *container.Info) string {
+	if info.Status == container.EXIT || info.Status == container.RESTARTING {
+		if info.OOMKilled {
+			return fmt.Sprintf("%s (%d) OOMKilled", info.Status, info.ExitCode)
+		}
+		return fmt.Sprintf("%s (%d)", info.Status, info.ExitCode)
+	}
+	return info.Status
+}
+
 func getContainerInfo(file os.FileInfo) (*container.Info, error) {
 	// get file name
 	containerName := file.Name()
@@ -3,7 +3,6 @@ package main
 import (
 	"os"
 
-	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
 	"github.com/onrik/logrus/filename"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
@@ -22,13 +21,25 @@ func main() {
 
 	app.Commands = []cli.Command{
 		initCommand,
+		shimCommand,
 		runCommand,
+		createCommand,
 		commitCommand,
 		listCommand,
+		inspectCommand,
+		statsCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		killCommand,
+		pauseCommand,
+		unpauseCommand,
+		startCommand,
+		restartCommand,
+		updateCommand,
 		removeCommand,
+		imageCommand,
+		loadCommand,
 	}
 
 	app.Before = func(context *cli.Context) error {
@@ -39,10 +50,10 @@ func main() {
 	}
 	if err := app.Run(os.Args); err != nil {
 		// do cleanup nonetheless
-		log.Infof("still try to do cleanup..")
-		mntURL := "/root/mnt/"
-		rootURL := "/root/"
-		container.DeleteWorkSpace(rootURL, mntURL, "")
+		if pendingContainer != "" {
+			log.Infof("still try to do cleanup for container %s..", pendingContainer)
+			cleanupContainer(pendingContainer)
+		}
 		log.Fatal(err)
 	}
 
@@ -3,20 +3,132 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/image"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
 
+// resourceFlags are the resource limits of a container that update can change while it runs,
+// run and create take them as well
+var resourceFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "m, memory",
+		Usage: "memory limit",
+	},
+	cli.StringFlag{
+		Name:  "memory-swap",
+		Usage: "memory plus swap limit, -1 for unlimited swap",
+	},
+	cli.StringFlag{
+		Name:  "memory-reservation",
+		Usage: "memory soft limit",
+	},
+	cli.StringFlag{
+		Name:  "pids-limit",
+		Usage: "the most processes the container may run, 0 or -1 for no limit",
+	},
+	cli.StringFlag{
+		Name:  "blkio-weight",
+		Usage: "block I/O weight, from 10 to 1000",
+	},
+	cli.StringFlag{
+		Name:  "cpushare",
+		Usage: "cpushare limit",
+	},
+	cli.StringFlag{
+		Name:  "cpuset",
+		Usage: "cpuset limit",
+	},
+	cli.StringFlag{
+		Name:  "cpus",
+		Usage: "number of CPUs the container may use, such as 1.5",
+	},
+	cli.StringFlag{
+		Name:  "cpu-period",
+		Usage: "CPU CFS period in microseconds",
+	},
+	cli.StringFlag{
+		Name:  "cpu-quota",
+		Usage: "CPU CFS quota in microseconds per period",
+	},
+}
+
+// containerFlags are the flags of both run and create, describing the container to create
+var containerFlags = append([]cli.Flag{
+	cli.BoolFlag{
+		Name:  "rm",
+		Usage: "remove the container once it exits",
+	},
+	cli.StringFlag{
+		Name:  "restart",
+		Usage: "restart policy of a detached container: no, on-failure[:max-retries], always or unless-stopped",
+	},
+	cli.StringFlag{
+		Name:  "v",
+		Usage: "volume",
+	},
+	cli.BoolFlag{
+		Name:  "oom-kill-disable",
+		Usage: "disable the OOM killer for the container",
+	},
+	cli.StringFlag{
+		Name:  "oom-score-adj",
+		Usage: "tune the OOM killer's preference for the container, from -1000 to 1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit read rate from a device, such as /dev/sda:10mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit write rate to a device, such as /dev/sda:10mb",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-read-iops",
+		Usage: "limit reads per second from a device, such as /dev/sda:1000",
+	},
+	cli.StringSliceFlag{
+		Name:  "device-write-iops",
+		Usage: "limit writes per second to a device, such as /dev/sda:1000",
+	},
+	cli.StringFlag{
+		Name:  "name",
+		Usage: "container name",
+	},
+	cli.StringFlag{
+		Name:  "cgroup-parent",
+		Usage: "parent of the cgroup of the container, mydocker by default",
+	},
+	cli.StringFlag{
+		Name:  "storage-driver",
+		Usage: "storage driver (overlay or aufs), detected from /proc/filesystems if not set",
+	},
+	cli.StringSliceFlag{
+		Name:  "e",
+		Usage: "set environment variables, KEY=VALUE or KEY to take the value from the host",
+	},
+	cli.StringSliceFlag{
+		Name:  "env-file",
+		Usage: "read environment variables from a file of KEY=VALUE lines",
+	},
+	cli.StringSliceFlag{
+		Name:  "ulimit",
+		Usage: "set a resource limit of the command, name=soft[:hard] such as nofile=1024:2048",
+	},
+}, resourceFlags...)
+
 // defines Flags of runCommanRund
 var runCommand = cli.Command{
 	Name: "run",
 	Usage: `Create a container with namespace and cgroups limit
-			mydocker run -ti [command]`,
-	Flags: []cli.Flag{
+			mydocker run -ti [image] [command]
+			the command is passed to the Entrypoint of the image and defaults to its Cmd`,
+	Flags: append([]cli.Flag{
 		cli.BoolFlag{
 			Name:  "ti",
 			Usage: "enable tty",
@@ -25,58 +137,83 @@ var runCommand = cli.Command{
 			Name:  "d",
 			Usage: "detach",
 		},
-		cli.StringFlag{
-			Name:  "v",
-			Usage: "volume",
-		},
-		cli.StringFlag{
-			Name:  "m",
-			Usage: "memory limit",
-		},
-		cli.StringFlag{
-			Name:  "cpushare",
-			Usage: "cpushare limit",
-		},
-		cli.StringFlag{
-			Name:  "cpuset",
-			Usage: "cpuset limit",
-		},
-		cli.StringFlag{
-			Name:  "name",
-			Usage: "container name",
-		},
-	},
+	}, containerFlags...),
 	/*
 		main func of runCommand
-		1. determines if args include command
-		2. get user-defined command
+		1. determines if args include image and command
+		2. get image name and user-defined command, the command may be left to the image
 		3. invokes Run function to start the container
 	*/
 	Action: func(context *cli.Context) error {
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("missing container command")
-		}
-		var cmdArray []string
-		for _, arg := range context.Args() {
-			cmdArray = append(cmdArray, arg)
+		return runContainer(context, false)
+	},
+}
+
+var createCommand = cli.Command{
+	Name: "create",
+	Usage: `Create a container without starting it, mydocker start starts it later
+			mydocker create [image] [command]`,
+	Flags: containerFlags,
+	Action: func(context *cli.Context) error {
+		return runContainer(context, true)
+	},
+}
+
+// runContainer parses the arguments of run and create into runOptions and invokes Run
+// a container that is only created runs detached once started
+func runContainer(context *cli.Context, create bool) error {
+	if len(context.Args()) < 1 {
+		return fmt.Errorf("missing image")
+	}
+	opts := &runOptions{
+		// the first argument is the image, the rest is the command
+		imageName:  context.Args().Get(0),
+		tty:        context.Bool("ti"),
+		create:     create,
+		autoRemove: context.Bool("rm"),
+		volume:     context.String("v"),
+		// pass container name, null if not specified
+		containerName: context.String("name"),
+		storageDriver: context.String("storage-driver"),
+		cgroupParent:  context.String("cgroup-parent"),
+		resources:     &subsystems.ResourceConfig{},
+	}
+	for _, arg := range context.Args().Tail() {
+		opts.cmdArray = append(opts.cmdArray, arg)
+	}
+	detach := context.Bool("d") || create
+	if opts.tty && detach {
+		return fmt.Errorf("ti and d parameters cannot be provided at the same time")
+	}
+	if err := parseResources(context, opts.resources); err != nil {
+		return err
+	}
+	log.Infof("tty enabled: %v", opts.tty)
+	// variables of -e override the ones of --env-file
+	var err error
+	if opts.env, err = parseEnv(context.StringSlice("env-file"), context.StringSlice("e")); err != nil {
+		return err
+	}
+	for _, spec := range context.StringSlice("ulimit") {
+		rlimit, err := container.ParseRlimit(spec)
+		if err != nil {
+			return err
 		}
-		tty := context.Bool("ti")
-		detach := context.Bool("d")
-		volume := context.String("v")
-		if tty && detach {
-			return fmt.Errorf("ti and d parameters cannot be provided at the same time")
+		opts.rlimits = append(opts.rlimits, rlimit)
+	}
+	if opts.restartPolicy, err = parseRestartPolicy(context.String("restart")); err != nil {
+		return err
+	}
+	if opts.restartPolicy.Name != restartNo {
+		// only the shim of a detached container is around to restart it
+		if !detach {
+			return fmt.Errorf("restart policies need the d parameter")
 		}
-		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: context.String("m"),
-			CPUShare:    context.String("cpushare"),
-			CPUSet:      context.String("cpuset"),
+		if opts.autoRemove {
+			return fmt.Errorf("restart and rm parameters cannot be provided at the same time")
 		}
-		log.Infof("tty enabled: %v", tty)
-		// pass container name, null if not specified
-		containerName := context.String("name")
-		Run(tty, volume, cmdArray, resConf, containerName)
-		return nil
-	},
+	}
+	return Run(opts)
 }
 
 // defines operations for initCommand
@@ -95,15 +232,36 @@ var initCommand = cli.Command{
 	},
 }
 
-var commitCommand = cli.Command{
-	Name:  "commit",
-	Usage: "Commit a container into an image",
+// defines operations for shimCommand, run -d starts it in the background for every container
+var shimCommand = cli.Command{
+	Name:  "shim",
+	Usage: "Monitor a detached container until it exits. Do not call it outside",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "create",
+			Usage: "wait for start before running the command of the container",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
-		imageName := context.Args().Get(0)
-		commitContainer(imageName)
+		containerName := context.Args().Get(0)
+		return runShim(containerName, context.Bool("create"))
+	},
+}
+
+var commitCommand = cli.Command{
+	Name: "commit",
+	Usage: `Commit a container into an image
+			mydocker commit [container name] [image name]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("missing container name or image name")
+		}
+		containerName := context.Args().Get(0)
+		imageName := context.Args().Get(1)
+		commitContainer(containerName, imageName)
 		return nil
 	},
 }
@@ -117,6 +275,55 @@ var listCommand = cli.Command{
 	},
 }
 
+var updateCommand = cli.Command{
+	Name: "update",
+	Usage: `Change the resource limits of a container without restarting it
+			mydocker update [options] [container name]`,
+	Flags: resourceFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return updateContainer(containerName, context)
+	},
+}
+
+var statsCommand = cli.Command{
+	Name: "stats",
+	Usage: `Show the live resource usage of running containers
+			mydocker stats [container name...]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "print the usage once instead of refreshing it",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "print the usage as json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		var containerNames []string
+		for _, arg := range context.Args() {
+			containerNames = append(containerNames, arg)
+		}
+		return statsContainers(containerNames, context.Bool("no-stream"), context.String("format"))
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "Print the info of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return inspectContainer(containerName)
+	},
+}
+
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "Print logs of a container",
@@ -158,18 +365,117 @@ var execCommand = cli.Command{
 }
 
 var stopCommand = cli.Command{
-	Name:  "stop",
-	Usage: "Stop a running container",
+	Name: "stop",
+	Usage: `Stop a running container
+			mydocker stop -t [seconds] [container name]`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait for the container to exit before killing it",
+			Value: 10,
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
 		containerName := context.Args().Get(0)
-		stopContainer(containerName)
+		timeout := time.Duration(context.Int("t")) * time.Second
+		return stopContainer(containerName, timeout)
+	},
+}
+
+var killCommand = cli.Command{
+	Name: "kill",
+	Usage: `Send a signal to a running container
+			mydocker kill -s [signal] [container name]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "s",
+			Usage: "signal to send, by name such as SIGHUP or by number",
+			Value: "KILL",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "signal every process of the container rather than only its init process",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		signal, err := parseSignal(context.String("s"))
+		if err != nil {
+			return err
+		}
+		killContainer(containerName, signal, context.Bool("all"))
 		return nil
 	},
 }
 
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "Freeze every process of a running container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return pauseContainer(containerName)
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "Thaw the processes of a paused container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return unpauseContainer(containerName)
+	},
+}
+
+var startCommand = cli.Command{
+	Name: "start",
+	Usage: `Start a stopped container in the background
+			mydocker start [container name]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return startExistingContainer(containerName)
+	},
+}
+
+var restartCommand = cli.Command{
+	Name: "restart",
+	Usage: `Stop a container and start it again
+			mydocker restart -t [seconds] [container name]`,
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait for the container to exit before killing it",
+			Value: 10,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		timeout := time.Duration(context.Int("t")) * time.Second
+		// a container that failed to stop is not started again
+		if err := stopContainer(containerName, timeout); err != nil {
+			return err
+		}
+		return startExistingContainer(containerName)
+	},
+}
+
 var removeCommand = cli.Command{
 	Name:  "rm",
 	Usage: "Remove a stopped container",
@@ -177,9 +483,79 @@ var removeCommand = cli.Command{
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
-		volume := context.String("v")
 		containerName := context.Args().Get(0)
-		removeContainer(containerName, volume)
+		removeContainer(containerName)
 		return nil
 	},
 }
+
+var loadCommand = cli.Command{
+	Name: "load",
+	Usage: `Load the images of a "docker save" archive
+			mydocker load -i [archive]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "archive to read from",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		archiveURL := context.String("i")
+		if archiveURL == "" {
+			return fmt.Errorf("missing archive")
+		}
+		images, err := image.LoadDockerArchive(archiveURL)
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			fmt.Printf("loaded image %s\n", img.Name)
+		}
+		return nil
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "Manage images",
+	Subcommands: []cli.Command{
+		{
+			Name: "import",
+			Usage: `Import the images of an OCI image layout directory
+			mydocker image import [oci layout dir] [image name]`,
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("missing oci layout dir")
+				}
+				layoutURL := context.Args().Get(0)
+				// the image name is optional, the ref name annotations of the layout are used otherwise
+				imageName := context.Args().Get(1)
+				images, err := image.ImportOCI(layoutURL, imageName)
+				if err != nil {
+					return err
+				}
+				for _, img := range images {
+					fmt.Printf("imported image %s\n", img.Name)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "export",
+			Usage: `Export an image as an OCI image layout directory
+			mydocker image export [image name] [dir]`,
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 2 {
+					return fmt.Errorf("missing image name or dir")
+				}
+				imageName := context.Args().Get(0)
+				dirURL := context.Args().Get(1)
+				if err := image.ExportOCI(imageName, dirURL); err != nil {
+					return err
+				}
+				fmt.Printf("exported image %s to %s\n", imageName, dirURL)
+				return nil
+			},
+		},
+	},
+}
@@ -52,8 +52,12 @@ __attribute__((constructor)) void enter_namespace(void) {
         }
         close(fd);
     }
+    // the command should only see the env of the container, not the variables meant for us
+    char *cmd = strdup(mydocker_cmd);
+    unsetenv("mydocker_pid");
+    unsetenv("mydocker_cmd");
     // run the designaetd command within namespace
-    int res = system(mydocker_cmd);
+    int res = system(cmd);
     exit(0);
     return;
 }
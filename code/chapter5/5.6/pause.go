@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// pauseContainer freezes every process in the cgroup of a running container
+func pauseContainer(containerName string) error {
+	_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		if containerInfo.Status != container.RUNNING {
+			return fmt.Errorf("container %s is %s, only running containers can be paused", containerName, containerInfo.Status)
+		}
+		cgroupManager := containerCgroupManager(containerInfo)
+		if err := cgroupManager.Freeze(); err != nil {
+			return fmt.Errorf("freeze container %s error %v", containerName, err)
+		}
+		containerInfo.Status = container.PAUSED
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("paused container %s", containerName)
+	return nil
+}
+
+// unpauseContainer thaws the processes of a paused container
+func unpauseContainer(containerName string) error {
+	_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		if containerInfo.Status != container.PAUSED {
+			return fmt.Errorf("container %s is %s, not paused", containerName, containerInfo.Status)
+		}
+		return thawContainer(containerInfo)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("unpaused container %s", containerName)
+	return nil
+}
+
+// thawContainer thaws the processes of the paused container described by containerInfo and marks it running again,
+// for the caller to record
+func thawContainer(containerInfo *container.Info) error {
+	cgroupManager := containerCgroupManager(containerInfo)
+	if err := cgroupManager.Thaw(); err != nil {
+		return fmt.Errorf("thaw container %s error %v", containerInfo.Name, err)
+	}
+	containerInfo.Status = container.RUNNING
+	return nil
+}
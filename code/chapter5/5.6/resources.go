@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// defaultCPUPeriod is the CFS period --cpus is translated with, the kernel default of 100ms
+const defaultCPUPeriod = 100000
+
+// parseResources sets the resource limits of a container given in the flags of run, create or update on res,
+// the limits not given are left as they are in res, and the result is checked up front rather than left to fail in the cgroup
+func parseResources(context *cli.Context, res *subsystems.ResourceConfig) error {
+	flags := []struct {
+		name  string
+		value *string
+	}{
+		{"m", &res.MemoryLimit},
+		{"memory-swap", &res.MemorySwap},
+		{"memory-reservation", &res.MemoryReservation},
+		{"oom-score-adj", &res.OOMScoreAdj},
+		{"pids-limit", &res.PidsLimit},
+		{"cpushare", &res.CPUShare},
+		{"cpuset", &res.CPUSet},
+	}
+	for _, flag := range flags {
+		if value := context.String(flag.name); value != "" {
+			*flag.value = value
+		}
+	}
+	if context.Bool("oom-kill-disable") {
+		res.OOMKillDisable = true
+	}
+	if res.PidsLimit != "" {
+		if n, err := strconv.ParseInt(res.PidsLimit, 10, 64); err != nil || n < -1 {
+			return fmt.Errorf("invalid pids-limit %s", res.PidsLimit)
+		}
+	}
+	if err := checkMemory(res); err != nil {
+		return err
+	}
+	if err := checkCPUSet(res.CPUSet); err != nil {
+		return err
+	}
+	if cpus := context.String("cpus"); cpus != "" {
+		if err := parseCPUQuota(res, cpus, context.String("cpu-period"), context.String("cpu-quota")); err != nil {
+			return err
+		}
+	} else {
+		// a new quota is checked against the period the container has, and the other way round
+		period, quota := context.String("cpu-period"), context.String("cpu-quota")
+		if period == "" {
+			period = res.CPUPeriod
+		}
+		if quota == "" {
+			quota = res.CPUQuota
+		}
+		if err := parseCPUQuota(res, "", period, quota); err != nil {
+			return err
+		}
+	}
+	return parseBlkio(res, context)
+}
+
+// parseBlkio sets the block I/O weight of res and its throttling, given per device as <path>:<rate>
+func parseBlkio(res *subsystems.ResourceConfig, context *cli.Context) error {
+	if weight := context.String("blkio-weight"); weight != "" {
+		// 0 leaves the weight as it is
+		n, err := strconv.Atoi(weight)
+		if err != nil || (n != 0 && (n < 10 || n > 1000)) {
+			return fmt.Errorf("invalid blkio-weight %s, it must be from 10 to 1000", weight)
+		}
+		if n != 0 {
+			res.BlkioWeight = weight
+		}
+	}
+	throttles := []struct {
+		name      string
+		devices   *[]subsystems.ThrottleDevice
+		parseRate func(string) (uint64, error)
+	}{
+		{"device-read-bps", &res.BlkioDeviceReadBps, parseBps},
+		{"device-write-bps", &res.BlkioDeviceWriteBps, parseBps},
+		{"device-read-iops", &res.BlkioDeviceReadIOps, parseIOps},
+		{"device-write-iops", &res.BlkioDeviceWriteIOps, parseIOps},
+	}
+	for _, throttle := range throttles {
+		specs := context.StringSlice(throttle.name)
+		if len(specs) == 0 {
+			continue
+		}
+		devices, err := parseThrottleDevices(specs, throttle.parseRate)
+		if err != nil {
+			return err
+		}
+		*throttle.devices = devices
+	}
+	return nil
+}
+
+// parseThrottleDevices resolves the device of every <path>:<rate> in specs to its major:minor numbers,
+// the rate is read with parseRate
+func parseThrottleDevices(specs []string, parseRate func(string) (uint64, error)) ([]subsystems.ThrottleDevice, error) {
+	var devices []subsystems.ThrottleDevice
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid device limit %s, it must be <path>:<rate>", spec)
+		}
+		rate, err := parseRate(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid device limit %s, %v", spec, err)
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Stat(spec[:i], &stat); err != nil {
+			return nil, fmt.Errorf("stat device %s error %v", spec[:i], err)
+		}
+		if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+			return nil, fmt.Errorf("%s is not a block device", spec[:i])
+		}
+		major, minor := splitDevice(uint64(stat.Rdev))
+		devices = append(devices, subsystems.ThrottleDevice{
+			Major: major,
+			Minor: minor,
+			Rate:  rate,
+		})
+	}
+	return devices, nil
+}
+
+// splitDevice splits the device number rdev into its major and minor numbers, as the major() and minor() macros of glibc do
+func splitDevice(rdev uint64) (int64, int64) {
+	major := ((rdev & 0x00000000000fff00) >> 8) | ((rdev & 0xfffff00000000000) >> 32)
+	minor := (rdev & 0x00000000000000ff) | ((rdev & 0x00000ffffff00000) >> 12)
+	return int64(major), int64(minor)
+}
+
+// parseBps reads a rate in bytes per second with the suffixes of memory sizes, such as 10mb
+func parseBps(rate string) (uint64, error) {
+	n, err := subsystems.ParseMemory(rate)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(n), nil
+}
+
+// parseIOps reads a rate in operations per second
+func parseIOps(rate string) (uint64, error) {
+	n, err := strconv.ParseUint(rate, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+	return n, nil
+}
+
+// checkCPUSet checks that a cpuset list such as 0-2,4 only names CPUs the host has
+func checkCPUSet(cpuset string) error {
+	if cpuset == "" {
+		return nil
+	}
+	hostCPUs := runtime.NumCPU()
+	for _, cpus := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(cpus, "-", 2)
+		for _, bound := range bounds {
+			n, err := strconv.Atoi(bound)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid cpuset %s", cpuset)
+			}
+			if n >= hostCPUs {
+				return fmt.Errorf("invalid cpuset %s, the host only has CPUs 0-%d", cpuset, hostCPUs-1)
+			}
+		}
+	}
+	return nil
+}
+
+// parseCPUQuota sets the CFS period and quota of res, either from cpus, a number of CPUs such as 1.5,
+// or from period and quota as they are, and checks they allow no more CPUs than the host has
+func parseCPUQuota(res *subsystems.ResourceConfig, cpus string, period string, quota string) error {
+	hostCPUs := runtime.NumCPU()
+	if cpus != "" {
+		if period != "" || quota != "" {
+			return fmt.Errorf("cpus and cpu-period or cpu-quota parameters cannot be provided at the same time")
+		}
+		n, err := strconv.ParseFloat(cpus, 64)
+		if err != nil || n < 0.01 || n > float64(hostCPUs) {
+			return fmt.Errorf("invalid cpus %s, the range of CPUs is from 0.01 to %d.00", cpus, hostCPUs)
+		}
+		res.CPUPeriod = strconv.Itoa(defaultCPUPeriod)
+		// 0.29 * 100000 is 28999.999999999996, round it rather than truncate it
+		res.CPUQuota = strconv.Itoa(int(math.Round(n * defaultCPUPeriod)))
+		return nil
+	}
+
+	periodUs := defaultCPUPeriod
+	if period != "" {
+		n, err := strconv.Atoi(period)
+		// the kernel takes periods from 1ms to 1s
+		if err != nil || n < 1000 || n > 1000000 {
+			return fmt.Errorf("invalid cpu-period %s, it must be from 1000 to 1000000 microseconds", period)
+		}
+		periodUs = n
+		res.CPUPeriod = period
+	}
+	if quota != "" {
+		n, err := strconv.Atoi(quota)
+		if err != nil || (n != -1 && n < 1000) {
+			return fmt.Errorf("invalid cpu-quota %s, it must be at least 1000 microseconds, or -1 for none", quota)
+		}
+		if n > periodUs*hostCPUs {
+			return fmt.Errorf("invalid cpu-quota %s, it allows more than the %d CPUs of the host", quota, hostCPUs)
+		}
+		res.CPUQuota = quota
+	}
+	return nil
+}
+
+// checkMemory checks the memory limits of res against each other,
+// and turns them into numbers of bytes, the cgroup files do not take sizes such as 100mb
+func checkMemory(res *subsystems.ResourceConfig) error {
+	var memory int64
+	if res.MemoryLimit != "" {
+		n, err := subsystems.ParseMemory(res.MemoryLimit)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid memory limit %s", res.MemoryLimit)
+		}
+		memory = n
+	}
+	if res.MemorySwap != "" && res.MemorySwap != "-1" {
+		if memory == 0 {
+			return fmt.Errorf("memory-swap needs a memory limit, set it with -m")
+		}
+		swap, err := subsystems.ParseMemory(res.MemorySwap)
+		if err != nil {
+			return fmt.Errorf("invalid memory-swap %s", res.MemorySwap)
+		}
+		// memory-swap counts memory as well, it cannot be below it
+		if swap < memory {
+			return fmt.Errorf("memory-swap %s must be at least the memory limit %s", res.MemorySwap, res.MemoryLimit)
+		}
+		res.MemorySwap = strconv.FormatInt(swap, 10)
+	}
+	if res.MemoryReservation != "" {
+		reservation, err := subsystems.ParseMemory(res.MemoryReservation)
+		if err != nil {
+			return fmt.Errorf("invalid memory-reservation %s", res.MemoryReservation)
+		}
+		if memory != 0 && reservation > memory {
+			return fmt.Errorf("memory-reservation %s must be at most the memory limit %s", res.MemoryReservation, res.MemoryLimit)
+		}
+		res.MemoryReservation = strconv.FormatInt(reservation, 10)
+	}
+	if memory != 0 {
+		res.MemoryLimit = strconv.FormatInt(memory, 10)
+	}
+	if res.OOMKillDisable && memory == 0 {
+		// with no limit the container only runs out of memory along with the host, which then has no way to get it back
+		log.Warnf("disabling the OOM killer without a memory limit may hang the host")
+	}
+	if res.OOMScoreAdj != "" {
+		score, err := strconv.Atoi(res.OOMScoreAdj)
+		if err != nil || score < -1000 || score > 1000 {
+			return fmt.Errorf("invalid oom-score-adj %s, it must be from -1000 to 1000", res.OOMScoreAdj)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+)
+
+func TestParseCPUQuota(t *testing.T) {
+	hostCPUs := runtime.NumCPU()
+	tooMany := strconv.Itoa(hostCPUs + 1)
+	tests := []struct {
+		cpus, period, quota   string
+		wantPeriod, wantQuota string
+		wantErr               bool
+	}{
+		{"1", "", "", "100000", "100000", false},
+		{"0.5", "", "", "100000", "50000", false},
+		// float products such as 0.29 * 100000 fall just short of the integer
+		{"0.29", "", "", "100000", "29000", false},
+		{"0.57", "", "", "100000", "57000", false},
+		{"0.01", "", "", "100000", "1000", false},
+		{"0.001", "", "", "", "", true},
+		{tooMany, "", "", "", "", true},
+		{"x", "", "", "", "", true},
+		{"1", "50000", "", "", "", true},
+		{"1", "", "50000", "", "", true},
+		{"", "50000", "25000", "50000", "25000", false},
+		{"", "", "50000", "", "50000", false},
+		{"", "", "-1", "", "-1", false},
+		{"", "", "999", "", "", true},
+		{"", "", "-2", "", "", true},
+		{"", "999", "", "", "", true},
+		{"", "1000001", "", "", "", true},
+		{"", "x", "", "", "", true},
+		{"", "100000", strconv.Itoa(100000*hostCPUs + 1), "", "", true},
+		{"", "", "", "", "", false},
+	}
+	for _, test := range tests {
+		res := &subsystems.ResourceConfig{}
+		err := parseCPUQuota(res, test.cpus, test.period, test.quota)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseCPUQuota(%q, %q, %q) error %v, want error %v", test.cpus, test.period, test.quota, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (res.CPUPeriod != test.wantPeriod || res.CPUQuota != test.wantQuota) {
+			t.Errorf("parseCPUQuota(%q, %q, %q) = period %q quota %q, want period %q quota %q",
+				test.cpus, test.period, test.quota, res.CPUPeriod, res.CPUQuota, test.wantPeriod, test.wantQuota)
+		}
+	}
+	// the rounding holds for more than one CPU as well
+	if hostCPUs >= 3 {
+		res := &subsystems.ResourceConfig{}
+		if err := parseCPUQuota(res, "2.3", "", ""); err != nil || res.CPUQuota != "230000" {
+			t.Errorf("parseCPUQuota(\"2.3\") = quota %q, error %v, want quota 230000", res.CPUQuota, err)
+		}
+	}
+}
+
+func TestCheckMemory(t *testing.T) {
+	tests := []struct {
+		memory, swap, reservation, oomScoreAdj string
+		wantMemory, wantSwap, wantReservation  string
+		wantErr                                bool
+	}{
+		{"100m", "", "", "", "104857600", "", "", false},
+		{"100mb", "200MB", "50m", "", "104857600", "209715200", "52428800", false},
+		{"1g", "-1", "", "", "1073741824", "-1", "", false},
+		{"4096", "4096", "", "", "4096", "4096", "", false},
+		{"", "", "64k", "", "", "", "65536", false},
+		{"", "", "", "500", "", "", "", false},
+		{"0", "", "", "", "", "", "", true},
+		{"100x", "", "", "", "", "", "", true},
+		{"", "200m", "", "", "", "", "", true},
+		{"100m", "50m", "", "", "", "", "", true},
+		{"100m", "lots", "", "", "", "", "", true},
+		{"100m", "", "200m", "", "", "", "", true},
+		{"", "", "some", "", "", "", "", true},
+		{"", "", "", "1001", "", "", "", true},
+		{"", "", "", "-1001", "", "", "", true},
+		{"", "", "", "x", "", "", "", true},
+	}
+	for _, test := range tests {
+		res := &subsystems.ResourceConfig{
+			MemoryLimit:       test.memory,
+			MemorySwap:        test.swap,
+			MemoryReservation: test.reservation,
+			OOMScoreAdj:       test.oomScoreAdj,
+		}
+		err := checkMemory(res)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkMemory(%q, %q, %q, %q) error %v, want error %v", test.memory, test.swap, test.reservation, test.oomScoreAdj, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && (res.MemoryLimit != test.wantMemory || res.MemorySwap != test.wantSwap || res.MemoryReservation != test.wantReservation) {
+			t.Errorf("checkMemory(%q, %q, %q) = %q, %q, %q, want %q, %q, %q", test.memory, test.swap, test.reservation,
+				res.MemoryLimit, res.MemorySwap, res.MemoryReservation, test.wantMemory, test.wantSwap, test.wantReservation)
+		}
+	}
+}
+
+func TestSplitDevice(t *testing.T) {
+	tests := []struct {
+		rdev         uint64
+		major, minor int64
+	}{
+		{0x0800, 8, 0},
+		{0x0703, 7, 3},
+		{0x0811, 8, 17},
+		// minors above 255 and majors above 4095 take the high bits
+		{0x10301, 259, 1},
+		{0x100800, 8, 256},
+		{0x100000000000, 4096, 0},
+		{0x000120006783459a, 0x12345, 0x6789a},
+		{0xffffffffffffffff, 0xffffffff, 0xffffffff},
+	}
+	for _, test := range tests {
+		major, minor := splitDevice(test.rdev)
+		if major != test.major || minor != test.minor {
+			t.Errorf("splitDevice(%#x) = %d:%d, want %d:%d", test.rdev, major, minor, test.major, test.minor)
+		}
+	}
+}
+
+func TestParseThrottleDevices(t *testing.T) {
+	for _, spec := range []string{"/dev/loop0", ":1mb", "/dev/loop0:lots", "/dev/null:1mb", "/no/such/device:1mb"} {
+		if _, err := parseThrottleDevices([]string{spec}, parseBps); err == nil {
+			t.Errorf("parseThrottleDevices(%q) succeeded", spec)
+		}
+	}
+	if _, err := os.Stat("/dev/loop0"); err != nil {
+		t.Skip("no /dev/loop0 to throttle")
+	}
+	devices, err := parseThrottleDevices([]string{"/dev/loop0:1mb"}, parseBps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0] != (subsystems.ThrottleDevice{Major: 7, Minor: 0, Rate: 1 << 20}) {
+		t.Errorf("parseThrottleDevices(\"/dev/loop0:1mb\") = %v, want 7:0 1048576", devices)
+	}
+}
+
+func TestCheckCPUSet(t *testing.T) {
+	last := strconv.Itoa(runtime.NumCPU() - 1)
+	beyond := strconv.Itoa(runtime.NumCPU())
+	tests := []struct {
+		cpuset  string
+		wantErr bool
+	}{
+		{"", false},
+		{"0", false},
+		{"0-" + last, false},
+		{"0," + last, false},
+		{beyond, true},
+		{"0-" + beyond, true},
+		{"0," + beyond, true},
+		{"-1", true},
+		{"a", true},
+		{"0,", true},
+		{"0-", true},
+	}
+	for _, test := range tests {
+		if err := checkCPUSet(test.cpuset); (err != nil) != test.wantErr {
+			t.Errorf("checkCPUSet(%q) error %v, want error %v", test.cpuset, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+)
+
+// the restart policies of --restart
+const (
+	restartNo            = "no"
+	restartOnFailure     = "on-failure"
+	restartAlways        = "always"
+	restartUnlessStopped = "unless-stopped"
+)
+
+// some constants
+var (
+	// the shim waits restartBackoffMin before the first restart and doubles the wait on every following one, up to restartBackoffMax
+	restartBackoffMin = 100 * time.Millisecond
+	restartBackoffMax = time.Minute
+	// a container that ran for restartBackoffReset before exiting starts over with restartBackoffMin
+	restartBackoffReset = 10 * time.Second
+)
+
+// parseRestartPolicy parses the value of --restart: no, on-failure[:max-retries], always or unless-stopped
+func parseRestartPolicy(policy string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	restartPolicy := container.RestartPolicy{Name: parts[0]}
+	switch restartPolicy.Name {
+	case "":
+		restartPolicy.Name = restartNo
+	case restartNo, restartAlways, restartUnlessStopped:
+	case restartOnFailure:
+		if len(parts) == 2 {
+			maxRetries, err := strconv.Atoi(parts[1])
+			if err != nil || maxRetries < 0 {
+				return restartPolicy, fmt.Errorf("invalid maximum retry count %s of restart policy", parts[1])
+			}
+			restartPolicy.MaximumRetryCount = maxRetries
+		}
+		return restartPolicy, nil
+	default:
+		return restartPolicy, fmt.Errorf("invalid restart policy %s", policy)
+	}
+	if len(parts) == 2 {
+		return restartPolicy, fmt.Errorf("restart policy %s takes no maximum retry count", restartPolicy.Name)
+	}
+	return restartPolicy, nil
+}
+
+// shouldRestart tells if the container described by containerInfo, which exited with exitCode, is to be started again
+// without a daemon that restarts along with the host, always and unless-stopped both leave stopped containers alone
+func shouldRestart(containerInfo *container.Info, exitCode int) bool {
+	if containerInfo.ManuallyStopped {
+		return false
+	}
+	switch containerInfo.RestartPolicy.Name {
+	case restartAlways, restartUnlessStopped:
+		return true
+	case restartOnFailure:
+		maxRetries := containerInfo.RestartPolicy.MaximumRetryCount
+		return exitCode != 0 && (maxRetries == 0 || containerInfo.RestartCount < maxRetries)
+	}
+	return false
+}
+
+// nextBackoff returns how long to wait before restarting a container that ran for runTime, after waiting backoff the last time
+func nextBackoff(backoff time.Duration, runTime time.Duration) time.Duration {
+	if backoff == 0 || runTime >= restartBackoffReset {
+		return restartBackoffMin
+	}
+	if backoff *= 2; backoff > restartBackoffMax {
+		return restartBackoffMax
+	}
+	return backoff
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    container.RestartPolicy
+		wantErr bool
+	}{
+		{"", container.RestartPolicy{Name: "no"}, false},
+		{"no", container.RestartPolicy{Name: "no"}, false},
+		{"always", container.RestartPolicy{Name: "always"}, false},
+		{"unless-stopped", container.RestartPolicy{Name: "unless-stopped"}, false},
+		{"on-failure", container.RestartPolicy{Name: "on-failure"}, false},
+		{"on-failure:3", container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, false},
+		{"on-failure:0", container.RestartPolicy{Name: "on-failure"}, false},
+		{"on-failure:-1", container.RestartPolicy{}, true},
+		{"on-failure:x", container.RestartPolicy{}, true},
+		{"always:3", container.RestartPolicy{}, true},
+		{"sometimes", container.RestartPolicy{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseRestartPolicy(test.policy)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseRestartPolicy(%q) error %v, want error %v", test.policy, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("parseRestartPolicy(%q) = %+v, want %+v", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy          string
+		maxRetries      int
+		restartCount    int
+		manuallyStopped bool
+		exitCode        int
+		want            bool
+	}{
+		{"no", 0, 0, false, 1, false},
+		{"always", 0, 0, false, 0, true},
+		{"always", 0, 100, false, 1, true},
+		{"always", 0, 0, true, 1, false},
+		{"unless-stopped", 0, 0, false, 0, true},
+		{"unless-stopped", 0, 0, true, 137, false},
+		{"on-failure", 0, 0, false, 0, false},
+		{"on-failure", 0, 50, false, 1, true},
+		{"on-failure", 3, 0, false, 1, true},
+		{"on-failure", 3, 2, false, 1, true},
+		{"on-failure", 3, 3, false, 1, false},
+		{"on-failure", 3, 0, true, 1, false},
+	}
+	for _, test := range tests {
+		info := &container.Info{
+			RestartPolicy:   container.RestartPolicy{Name: test.policy, MaximumRetryCount: test.maxRetries},
+			RestartCount:    test.restartCount,
+			ManuallyStopped: test.manuallyStopped,
+		}
+		if got := shouldRestart(info, test.exitCode); got != test.want {
+			t.Errorf("shouldRestart(%+v, %d) = %v, want %v", test, test.exitCode, got, test.want)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		runTime time.Duration
+		want    time.Duration
+	}{
+		{0, 0, restartBackoffMin},
+		{restartBackoffMin, time.Second, 2 * restartBackoffMin},
+		{800 * time.Millisecond, time.Second, 1600 * time.Millisecond},
+		{40 * time.Second, time.Second, restartBackoffMax},
+		{restartBackoffMax, time.Second, restartBackoffMax},
+		// a container that ran long enough starts over
+		{restartBackoffMax, restartBackoffReset, restartBackoffMin},
+		{10 * time.Second, time.Hour, restartBackoffMin},
+	}
+	for _, test := range tests {
+		if got := nextBackoff(test.backoff, test.runTime); got != test.want {
+			t.Errorf("nextBackoff(%v, %v) = %v, want %v", test.backoff, test.runTime, got, test.want)
+		}
+	}
+}
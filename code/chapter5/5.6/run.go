@@ -1,128 +1,418 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/exec"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups"
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/image"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/storage"
 	log "github.com/sirupsen/logrus"
 )
 
+// pendingContainer is the name of the container Run is currently setting up,
+// main uses it to clean up the container's workspace if the run fails
+var pendingContainer string
+
+// runOptions describe the container run and create are asked for, as given in their flags
+type runOptions struct {
+	tty           bool                       // attach the container to the terminal instead of detaching it
+	create        bool                       // set up the container but leave its command for start to run
+	autoRemove    bool                       // remove the container once it exits
+	volume        string                     // the data volume to mount, in the form of host:container
+	cmdArray      []string                   // the command given to the entrypoint of the image, its default command if empty
+	resources     *subsystems.ResourceConfig // the resource limits of the container
+	containerName string                     // the container name, the id if empty
+	imageName     string                     // the image to start the container from
+	storageDriver string                     // the storage driver, detected if empty
+	env           []string                   // the environment variables given by the user, in the form of KEY=VALUE
+	rlimits       []container.Rlimit         // the resource limits of the init process
+	restartPolicy container.RestartPolicy    // whether the shim starts the container again once it exits
+	cgroupParent  string                     // the parent of the cgroup of the container, mydocker if empty
+}
+
 // Run Actually runs the created command. Clones a process with namespace isolation, and runs /proc/self/exe in child process, sends parameters for init, and runs init to initialize the container's resources
-func Run(tty bool, volume string, comArray []string, res *subsystems.ResourceConfig, containerName string) {
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume)
-	if parent == nil {
-		log.Errorf("new parent process error")
-		return
+// with create the container is set up but its command only runs once mydocker start releases it
+func Run(opts *runOptions) error {
+	// first we get a 10-digit number as container ID
+	id := randStringBytes(10)
+	// if user did not specify a container name, use id instead
+	containerName := opts.containerName
+	if containerName == "" {
+		containerName = id
 	}
-	if err := parent.Start(); err != nil {
-		log.Error(err)
+	// the name keys both the container's metadata and its workspace, so it must be unused
+	if exist, _ := container.PathExists(fmt.Sprintf(container.DefaultInfoLocation, containerName)); exist {
+		return fmt.Errorf("container name %s is already in use", containerName)
 	}
-
-	// record info about the container
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName)
+	// pick the storage driver given by the user, or the first one the kernel supports
+	driver, err := storage.GetDriver(opts.storageDriver)
 	if err != nil {
-		log.Errorf("record container info error %v", err)
-		return
+		return err
+	}
+	log.Infof("using storage driver %s", driver.Name())
+	img, err := image.GetImage(opts.imageName)
+	if err != nil {
+		return err
 	}
+	// the command runs through the entrypoint of the image,
+	// without a command from the user the default command of the image is used
+	comArray := img.Command(opts.cmdArray)
+	if len(comArray) == 0 {
+		return fmt.Errorf("missing container command, image %s has no default command", opts.imageName)
+	}
+	// the variables given by the user override the ones of the image
+	env := container.MergeEnv(img.Config.Env, opts.env)
+	pendingContainer = containerName
 
-	// use mydocker-cgroup as cgroup name
-	// create cgroup manager, use set() and apply() to set resources of the container
-	cgroupManager := cgroups.NewCgroupManager("mydocker-cgroup")
-	defer cgroupManager.Destroy()
-	// set resource restrictions
-	cgroupManager.Set(res)
-	// add container process into cgroups mounted by each subsystem
-	cgroupManager.Apply(parent.Process.Pid)
-	log.Infof("finished setting up cgroup")
-	// initialize the container, send the user commands to child
-	sendInitCommand(comArray, writePipe)
-	if tty {
-		parent.Wait()
-		deleteContainerInfo(containerName)
-		mntURL := "/root/mnt/"
-		rootURL := "/root/"
-		if _, err := os.Stat(mntURL); !os.IsNotExist(err) {
-			container.DeleteWorkSpace(rootURL, mntURL, volume)
+	// every container gets its own write layer and mount point, keyed by containerName,
+	// stacked on top of the read-only layers of the image by the storage driver
+	if err := container.NewWorkSpace(opts.volume, img, containerName, driver); err != nil {
+		return fmt.Errorf("new workspace error %v", err)
+	}
+	// every container gets a cgroup of its own, named after its id
+	cgroupParent := opts.cgroupParent
+	if cgroupParent == "" {
+		cgroupParent = container.DefaultCgroupParent
+	}
+	cgroupPath := path.Join(cgroupParent, id)
+	// record info about the container, it holds everything needed to start the container
+	containerInfo, err := recordContainerInfo(opts, id, containerName, comArray, img, driver.Name(), env, cgroupPath)
+	if err != nil {
+		return fmt.Errorf("record container info error %v", err)
+	}
+	if !opts.tty {
+		// the shim starts the container in the background and stays around to wait for it
+		if err := startShim(containerName, opts.create); err != nil {
+			return err
 		}
+		os.Exit(0)
 	}
 
+	parent, cgroupManager, oom, err := startContainer(containerInfo, opts.tty)
+	if err != nil {
+		return err
+	}
+	exitCode := waitContainer(parent, containerName, oom)
+	finishContainer(containerName, cgroupManager, exitCode)
+	// hand the container's exit code on to whoever ran us
+	os.Exit(exitCode)
+
 	// this issue is solved in pivotRoot() in init.go, so the method below is no longer needed
 	// adding the following lines will solve a bug which causes terminal to not accept some commands (i.e. sudo) after exiting
 	/*sysMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RELATIME
 	if err := syscall.Mount("proc", "/proc", "proc", uintptr(sysMountFlags), ""); err != nil {
 		log.Errorf("mount /proc error %v", err)
 	}*/
-	os.Exit(0)
-}
-
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Infof("complete command is %s", command)
-	writePipe.WriteString(command)
-	writePipe.Close()
+	return nil
 }
 
 // recordContainerInfo writes metadata of the container to the file system
-func recordContainerInfo(containerPID int, commandArray []string, containerName string) (string, error) {
-	// first we get a 10-digit number as container ID
-	id := randStringBytes(10)
+func recordContainerInfo(opts *runOptions, id string, containerName string, commandArray []string, img *image.Image, storageDriver string, env []string, cgroupPath string) (*container.Info, error) {
 	// use current time as container creation time
 	creationTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
-	// if user did not specify a container name, use id instead
-	if containerName == "" {
-		containerName = id
-	}
+	command := strings.Join(commandArray, " ")
 	log.Infof("using %s as container name", containerName)
 	containerInfo := &container.Info{
-		Id:           id,
-		Pid:          strconv.Itoa(containerPID),
-		Command:      command,
-		CreationTime: creationTime,
-		Status:       container.RUNNING,
-		Name:         containerName,
-	}
-
-	// convert the containerInfor object into its json encoding
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("record container info error %v", err)
-		return "", err
+		Id:            id,
+		Pid:           " ",
+		Command:       command,
+		CreationTime:  creationTime,
+		Status:        container.CREATED,
+		Name:          containerName,
+		Image:         img.Name,
+		Layers:        img.Layers,
+		Volume:        opts.volume,
+		MntURL:        fmt.Sprintf(container.MntURL, containerName),
+		WriteLayerURL: fmt.Sprintf(container.WriteLayerURL, containerName),
+		WorkURL:       fmt.Sprintf(container.WorkURL, containerName),
+		StorageDriver: storageDriver,
+		Env:           env,
+		Args:          commandArray,
+		Cwd:           img.Config.WorkingDir,
+		User:          img.Config.User,
+		StopSignal:    img.Config.StopSignal,
+		CgroupPath:    cgroupPath,
+		Resources:     opts.resources,
+		Rlimits:       opts.rlimits,
+		AutoRemove:    opts.autoRemove,
+		RestartPolicy: opts.restartPolicy,
 	}
-	jsonStr := string(jsonBytes)
 
 	// piece together the path of the file to write to
 	saveDirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
 	// if the directory does not exist, we need to recursively mkdir all of them
 	if err := os.MkdirAll(saveDirURL, 0622); err != nil {
 		log.Errorf("mkdir %s error %v", saveDirURL, err)
-		return "", err
+		return nil, err
 	}
 	saveFileName := path.Join(saveDirURL, container.ConfigName)
-	// create the config.json config file
-	file, err := os.Create(saveFileName)
-	defer file.Close()
+	// write the json-ized data into the config.json config file
+	if err := updateContainerInfo(containerInfo); err != nil {
+		log.Errorf("record container info error %v", err)
+		return nil, err
+	}
+	log.Infof("written config file for container[Name: %s, ID: %s] to %s", containerName, id, saveFileName)
+
+	return containerInfo, nil
+}
+
+// startContainer starts the init process of the container described by containerInfo in its workspace,
+// puts it into its cgroup and sends it the user's command
+// the OOM killer is watched from before the command runs, for waitContainer to tell whether it struck
+func startContainer(containerInfo *container.Info, tty bool) (*exec.Cmd, *cgroups.CgroupManager, *oomWatch, error) {
+	parent, writePipe, cgroupManager, err := createContainerProcess(containerInfo, tty)
 	if err != nil {
-		log.Errorf("create file %s error %v", saveFileName, err)
-		return "", err
+		return nil, nil, nil, err
+	}
+	oom := watchOOM(containerInfo.Name, cgroupManager)
+	if err := releaseContainer(containerInfo, parent, writePipe); err != nil {
+		oom.stop()
+		parent.Wait()
+		return nil, nil, nil, err
+	}
+	return parent, cgroupManager, oom, nil
+}
+
+// createContainerProcess starts the init process of the container described by containerInfo in its namespaces
+// and puts it into its cgroup, the init process then waits for its command on the other end of the returned pipe
+func createContainerProcess(containerInfo *container.Info, tty bool) (*exec.Cmd, *os.File, *cgroups.CgroupManager, error) {
+	parent, writePipe := container.NewParentProcess(tty, containerInfo.Name)
+	if parent == nil {
+		return nil, nil, nil, fmt.Errorf("new parent process error")
 	}
-	// write the json-ized data into the file
-	if _, err := file.WriteString(jsonStr); err != nil {
-		log.Errorf("file write to %s error %v", file, err)
-		return "", err
+	if err := parent.Start(); err != nil {
+		return nil, nil, nil, err
 	}
-	log.Infof("written config file for container[Name: %s, ID: %s] to %s", containerName, id, saveFileName)
 
-	return containerName, nil
+	// create cgroup manager, use set() and apply() to set resources of the container
+	cgroupManager := containerCgroupManager(containerInfo)
+	// set resource restrictions, a container must not run without the limits it was given
+	if err := cgroupManager.Set(containerInfo.Resources); err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+		return nil, nil, nil, err
+	}
+	// add container process into cgroups mounted by each subsystem, a process left out of a cgroup escapes its limits
+	if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+		return nil, nil, nil, err
+	}
+	log.Infof("finished setting up cgroup")
+	if containerInfo.Resources != nil && containerInfo.Resources.OOMScoreAdj != "" {
+		if err := setOOMScoreAdj(parent.Process.Pid, containerInfo.Resources.OOMScoreAdj); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+
+	recordedInfo, err := modifyContainerInfo(containerInfo.Name, func(containerInfo *container.Info) error {
+		containerInfo.Pid = strconv.Itoa(parent.Process.Pid)
+		containerInfo.Status = container.CREATED
+		containerInfo.OOMKilled = false
+		return nil
+	})
+	if err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		return nil, nil, nil, err
+	}
+	*containerInfo = *recordedInfo
+	return parent, writePipe, cgroupManager, nil
+}
+
+// releaseContainer lets the init process of a created container run the user's command
+// if that fails, the init process is killed and left for the caller to wait for
+func releaseContainer(containerInfo *container.Info, parent *exec.Cmd, writePipe *os.File) error {
+	// initialize the container, send the user command and the rest of its settings to child
+	initConfig := containerInfo.InitConfig()
+	log.Infof("complete command is %q", initConfig.Args)
+	if err := container.SendInitConfig(initConfig, writePipe); err != nil {
+		parent.Process.Kill()
+		return err
+	}
+	_, err := modifyContainerInfo(containerInfo.Name, func(containerInfo *container.Info) error {
+		containerInfo.Status = container.RUNNING
+		return nil
+	})
+	if err != nil {
+		parent.Process.Kill()
+		return err
+	}
+	containerInfo.Status = container.RUNNING
+	return nil
+}
+
+// containerCgroupManager returns the manager of the cgroup of the container described by containerInfo
+func containerCgroupManager(containerInfo *container.Info) *cgroups.CgroupManager {
+	if containerInfo.CgroupPath == "" {
+		// containers recorded before every container got its own cgroup all share this one
+		return cgroups.NewCgroupManager("mydocker-cgroup")
+	}
+	return cgroups.NewCgroupManager(containerInfo.CgroupPath)
+}
+
+// oomWatch tells whether the OOM killer struck a container, it is set up before the container runs its command
+type oomWatch struct {
+	cgroupManager *cgroups.CgroupManager
+	// the oom_kill count of the cgroup before the container ran, if the kernel keeps one
+	oomKills    uint64
+	hasOOMKills bool
+	// the OOM events of the cgroup, for kernels without the count
+	events     <-chan struct{}
+	stopEvents func()
+}
+
+// watchOOM reads the oom_kill count of the cgroup of the container and starts watching its OOM events
+func watchOOM(containerName string, cgroupManager *cgroups.CgroupManager) *oomWatch {
+	oom := &oomWatch{cgroupManager: cgroupManager}
+	if count, err := cgroupManager.OOMKills(); err == nil {
+		oom.oomKills = count
+		oom.hasOOMKills = true
+	} else {
+		log.Warnf("read OOM kills of container %s error %v", containerName, err)
+	}
+	events, stopEvents, err := cgroupManager.NotifyOOM()
+	if err != nil {
+		log.Warnf("watch OOM events of container %s error %v", containerName, err)
+	} else {
+		oom.events = events
+		oom.stopEvents = stopEvents
+	}
+	return oom
+}
+
+// killed tells whether the OOM killer struck since watchOOM, once the container has exited
+// the count is read again while the cgroup is still there, the events only stand in for it
+func (o *oomWatch) killed() bool {
+	if o.hasOOMKills {
+		if count, err := o.cgroupManager.OOMKills(); err == nil {
+			return count > o.oomKills
+		}
+	}
+	select {
+	case _, ok := <-o.events:
+		return ok
+	default:
+		return false
+	}
+}
+
+// stop stops watching the OOM events
+func (o *oomWatch) stop() {
+	if o.stopEvents != nil {
+		o.stopEvents()
+	}
+}
+
+// waitContainer waits for the init process of the container to exit and returns its exit code,
+// and records whether the OOM killer struck it as oom tells
+func waitContainer(parent *exec.Cmd, containerName string, oom *oomWatch) int {
+	defer oom.stop()
+	// Wait returns an error for a non-zero exit status, which is what we are after
+	parent.Wait()
+	exitCode := exitCodeOf(parent.ProcessState)
+	log.Infof("container %s exited with code %d", containerName, exitCode)
+
+	if oom.killed() {
+		log.Warnf("container %s ran out of memory", containerName)
+		recordOOMKilled(containerName)
+	}
+	return exitCode
+}
+
+// recordOOMKilled marks in the info of the container that the OOM killer struck it
+func recordOOMKilled(containerName string) {
+	_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		containerInfo.OOMKilled = true
+		return nil
+	})
+	if err != nil {
+		log.Errorf("update container %s's info error %v", containerName, err)
+	}
+}
+
+// setOOMScoreAdj sets how likely the OOM killer is to pick the process pid, its children inherit it
+func setOOMScoreAdj(pid int, score string) error {
+	scoreURL := fmt.Sprintf("/proc/%d/oom_score_adj", pid)
+	if err := ioutil.WriteFile(scoreURL, []byte(score), 0644); err != nil {
+		return fmt.Errorf("write %s error %v", scoreURL, err)
+	}
+	return nil
+}
+
+// recordContainerExit records the exit status of the container in its info, along with its new status
+func recordContainerExit(containerName string, exitCode int, status string) {
+	_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		containerInfo.Status = status
+		containerInfo.Pid = " "
+		containerInfo.ExitCode = exitCode
+		containerInfo.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+		return nil
+	})
+	if err != nil {
+		log.Errorf("update container %s's info error %v", containerName, err)
+	}
+}
+
+// exitCodeOf returns the exit code of a process like a shell does, 128+N if it was killed by signal N
+func exitCodeOf(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// finishContainer releases what an exited container held while running: its cgroup and the mounts of its workspace
+// the write layer is kept until the container is removed, unless it was run with --rm
+// the exit is recorded last, so that whoever sees the container exited can start it again right away
+func finishContainer(containerName string, cgroupManager *cgroups.CgroupManager, exitCode int) {
+	cgroupManager.Destroy()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		log.Errorf("get container %s's info error %v", containerName, err)
+		return
+	}
+	if containerInfo.AutoRemove {
+		cleanupContainer(containerName)
+		return
+	}
+	if err := container.UnmountWorkSpace(containerInfo); err != nil {
+		log.Errorf("unmount workspace of container %s error %v", containerName, err)
+	}
+	recordContainerExit(containerName, exitCode, container.EXIT)
+}
+
+// cleanupContainer removes the workspace and metadata of a container that failed to start or is done
+func cleanupContainer(containerName string) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		// the container died before its info got recorded, fall back to the default locations
+		// and whichever storage driver the kernel supports
+		containerInfo = &container.Info{
+			Name:          containerName,
+			MntURL:        fmt.Sprintf(container.MntURL, containerName),
+			WriteLayerURL: fmt.Sprintf(container.WriteLayerURL, containerName),
+			WorkURL:       fmt.Sprintf(container.WorkURL, containerName),
+		}
+	}
+	container.DeleteWorkSpace(containerInfo)
+	deleteContainerInfo(containerName)
 }
 
 func deleteContainerInfo(containerName string) {
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// shimReady is what the shim sends to run once the container is running,
+// anything else it sends is the error that kept the container from starting
+const shimReady = "ready"
+
+/*
+	startShim
+	This is executed by run -d
+	1. the shim runs in a session of its own, so it outlives run and the terminal run was called from
+	2. its own logs go to shim.log in the container's info dir, the container's output goes to container.log
+	3. it gets the write end of a pipe as its 4th file descriptor and reports through it whether the container started
+	4. with create, the shim reports once the container is set up and runs its command when start tells it to
+*/
+func startShim(containerName string, create bool) error {
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return fmt.Errorf("new pipe error %v", err)
+	}
+	defer readPipe.Close()
+	logURL := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), container.ShimLogFile)
+	logFile, err := os.Create(logURL)
+	if err != nil {
+		writePipe.Close()
+		return fmt.Errorf("create file %s error %v", logURL, err)
+	}
+	defer logFile.Close()
+
+	args := []string{"shim"}
+	if create {
+		args = append(args, "--create")
+	}
+	cmd := exec.Command("/proc/self/exe", append(args, containerName)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.ExtraFiles = []*os.File{writePipe}
+	err = cmd.Start()
+	// only the shim may hold the write end, so that reading ends when the shim closes it or dies
+	writePipe.Close()
+	if err != nil {
+		return fmt.Errorf("start shim of container %s error %v", containerName, err)
+	}
+	msg, err := ioutil.ReadAll(readPipe)
+	if err != nil {
+		return fmt.Errorf("read from shim of container %s error %v", containerName, err)
+	}
+	if string(msg) != shimReady {
+		if len(msg) == 0 {
+			return fmt.Errorf("shim of container %s exited before starting it, see %s", containerName, logURL)
+		}
+		return fmt.Errorf("start container %s error %s", containerName, msg)
+	}
+	log.Infof("shim of container %s is running with pid %d", containerName, cmd.Process.Pid)
+	// the shim is on its own from here, nobody waits for it
+	cmd.Process.Release()
+	return nil
+}
+
+// runShim is the shim of a detached container: it starts the container, reports to run whether that worked,
+// then waits for the container to exit to record its exit status, restart it as its restart policy says,
+// and finally release its cgroup and workspace
+// with create, it reports as soon as the container is set up, and waits for start before running its command
+func runShim(containerName string, create bool) error {
+	statusPipe := os.NewFile(uintptr(3), "pipe")
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		statusPipe.WriteString(err.Error())
+		return err
+	}
+	parent, writePipe, cgroupManager, err := createContainerProcess(containerInfo, false)
+	if err != nil {
+		statusPipe.WriteString(err.Error())
+		return err
+	}
+	// the init process may exit before it is released, so it is waited for from the start
+	startedAt := time.Now()
+	oom := watchOOM(containerName, cgroupManager)
+	exitCodes := make(chan int, 1)
+	go func() {
+		exitCodes <- waitContainer(parent, containerName, oom)
+	}()
+
+	if create {
+		started, err := waitStartFifo(containerName)
+		if err != nil {
+			parent.Process.Kill()
+			statusPipe.WriteString(err.Error())
+			finishContainer(containerName, cgroupManager, <-exitCodes)
+			return err
+		}
+		statusPipe.WriteString(shimReady)
+		statusPipe.Close()
+		log.Infof("shim is waiting for container %s to be started", containerName)
+		select {
+		case <-started:
+		case exitCode := <-exitCodes:
+			log.Infof("container %s exited before being started", containerName)
+			finishContainer(containerName, cgroupManager, exitCode)
+			return nil
+		}
+		startedAt = time.Now()
+	}
+	err = releaseContainer(containerInfo, parent, writePipe)
+	if !create {
+		if err != nil {
+			statusPipe.WriteString(err.Error())
+		} else {
+			statusPipe.WriteString(shimReady)
+		}
+		statusPipe.Close()
+	}
+	if err != nil {
+		log.Errorf("start container %s error %v", containerName, err)
+		finishContainer(containerName, cgroupManager, <-exitCodes)
+		return err
+	}
+	log.Infof("shim is waiting for container %s with pid %d", containerName, parent.Process.Pid)
+
+	var backoff time.Duration
+	exitCode := <-exitCodes
+	for {
+		// the info is read again, stop may have marked the container in the meantime
+		if containerInfo, err = getContainerInfoByName(containerName); err != nil || !shouldRestart(containerInfo, exitCode) {
+			break
+		}
+		// the container keeps its workspace while it waits to be restarted
+		recordContainerExit(containerName, exitCode, container.RESTARTING)
+		backoff = nextBackoff(backoff, time.Since(startedAt))
+		log.Infof("restarting container %s in %v", containerName, backoff)
+		time.Sleep(backoff)
+		// stop may mark the container while the shim sleeps, so the policy is checked again along with counting the restart
+		restart := false
+		containerInfo, err = modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+			if restart = shouldRestart(containerInfo, exitCode); restart {
+				containerInfo.RestartCount++
+			}
+			return nil
+		})
+		if err != nil || !restart {
+			break
+		}
+		if parent, cgroupManager, oom, err = startContainer(containerInfo, false); err != nil {
+			log.Errorf("restart container %s error %v", containerName, err)
+			break
+		}
+		startedAt = time.Now()
+		exitCode = waitContainer(parent, containerName, oom)
+	}
+	finishContainer(containerName, cgroupManager, exitCode)
+	return nil
+}
+
+// waitStartFifo makes the start fifo of the container, through which start tells the shim to run the command of a created container
+// the returned channel is closed once start has written to the fifo
+// the read end is open by the time this returns, so that start can open the fifo as soon as the shim reports ready
+func waitStartFifo(containerName string) (<-chan struct{}, error) {
+	fifoURL := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), container.StartFifoName)
+	os.Remove(fifoURL)
+	if err := syscall.Mkfifo(fifoURL, 0600); err != nil {
+		return nil, fmt.Errorf("mkfifo %s error %v", fifoURL, err)
+	}
+	// opening the read end blocks until there is a writer, unless it is opened without blocking
+	fifo, err := os.OpenFile(fifoURL, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open fifo %s error %v", fifoURL, err)
+	}
+	if err := syscall.SetNonblock(int(fifo.Fd()), false); err != nil {
+		fifo.Close()
+		return nil, fmt.Errorf("set fifo %s blocking error %v", fifoURL, err)
+	}
+	// a read with no writer returns at once, the shim holds a write end itself so that reading waits for start
+	holder, err := os.OpenFile(fifoURL, os.O_WRONLY, 0)
+	if err != nil {
+		fifo.Close()
+		return nil, fmt.Errorf("open fifo %s error %v", fifoURL, err)
+	}
+	started := make(chan struct{})
+	go func() {
+		defer holder.Close()
+		defer fifo.Close()
+		if _, err := fifo.Read(make([]byte, 1)); err != nil {
+			log.Errorf("read fifo %s error %v", fifoURL, err)
+			return
+		}
+		os.Remove(fifoURL)
+		close(started)
+	}()
+	return started, nil
+}
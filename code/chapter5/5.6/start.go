@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// some constants
+var (
+	// how often start checks whether a created container is running
+	startPollInterval = 100 * time.Millisecond
+	// how long start waits for the shim to run the command of a created container
+	startTimeout = 10 * time.Second
+)
+
+/*
+	startExistingContainer
+	1. a created container is released by telling its shim to send the command to its waiting init process
+	2. otherwise it mounts the workspace of a stopped or exited container again, its write layer is still there
+	3. and starts a shim for it like run -d does, which runs the command of the container again from its stored config:
+	   the command, volume, resource limits and env it was run with
+*/
+func startExistingContainer(containerName string) error {
+	containerInfo, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		if containerInfo.Status != container.CREATED && containerInfo.Status != container.STOP && containerInfo.Status != container.EXIT {
+			return fmt.Errorf("container %s is %s, only created or stopped containers can be started", containerName, containerInfo.Status)
+		}
+		// the restart policy applies again once the container is started by hand
+		containerInfo.ManuallyStopped = false
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if containerInfo.Status == container.CREATED {
+		return releaseCreatedContainer(containerName)
+	}
+	if err := container.MountWorkSpace(containerInfo); err != nil {
+		return fmt.Errorf("mount workspace of container %s error %v", containerName, err)
+	}
+	if err := startShim(containerName, false); err != nil {
+		if err := container.UnmountWorkSpace(containerInfo); err != nil {
+			log.Errorf("unmount workspace of container %s error %v", containerName, err)
+		}
+		return err
+	}
+	log.Infof("started container %s", containerName)
+	return nil
+}
+
+// releaseCreatedContainer tells the shim of a created container to run its command through the start fifo,
+// and waits for the container to be running
+func releaseCreatedContainer(containerName string) error {
+	fifoURL := path.Join(fmt.Sprintf(container.DefaultInfoLocation, containerName), container.StartFifoName)
+	// without blocking, opening fails if the shim is not there to read
+	fifo, err := os.OpenFile(fifoURL, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("container %s is not waiting to be started: %v", containerName, err)
+	}
+	_, err = fifo.Write([]byte{0})
+	fifo.Close()
+	if err != nil {
+		return fmt.Errorf("write to %s error %v", fifoURL, err)
+	}
+	for deadline := time.Now().Add(startTimeout); time.Now().Before(deadline); time.Sleep(startPollInterval) {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+			return fmt.Errorf("get container %s's info error %v", containerName, err)
+		}
+		switch containerInfo.Status {
+		case container.CREATED:
+			continue
+		case container.RUNNING:
+			log.Infof("started container %s", containerName)
+			return nil
+		default:
+			return fmt.Errorf("container %s is %s after starting, see mydocker logs %s", containerName, statusString(containerInfo), containerName)
+		}
+	}
+	return fmt.Errorf("container %s did not start in %v", containerName, startTimeout)
+}
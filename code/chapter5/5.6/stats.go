@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	log "github.com/sirupsen/logrus"
+)
+
+// statsInterval is how often stats samples the cgroups of the containers, CPU % is the share of it they spent on CPUs
+const statsInterval = time.Second
+
+// containerStats is the resource usage of a container over the last interval, as stats prints it
+type containerStats struct {
+	Id            string                `json:"id"`
+	Name          string                `json:"name"`
+	CPUPercent    float64               `json:"cpuPercent"`           // 100 per CPU kept busy
+	MemoryUsage   uint64                `json:"memoryUsage"`          // bytes
+	MemoryLimit   uint64                `json:"memoryLimit"`          // bytes, the memory of the host if the container has no limit
+	MemoryPercent float64               `json:"memoryPercent"`        // of the limit
+	Pids          *subsystems.PidsStats `json:"pids,omitempty"`       // left out if the kernel does not count the processes
+	BlockRead     *uint64               `json:"blockRead,omitempty"`  // bytes, left out if the kernel does not account for block I/O
+	BlockWrite    *uint64               `json:"blockWrite,omitempty"` // bytes
+}
+
+// statsSample is the usage of a container's cgroup at a point in time
+type statsSample struct {
+	stats *subsystems.Stats
+	at    time.Time
+}
+
+/*
+	statsContainers
+	This prints the resource usage of running containers, read from their cgroups
+	1. without names, every running container is shown, and the ones started meanwhile are picked up
+	2. the usage is sampled every statsInterval, CPU % is the CPU time spent between two samples
+	3. the table is redrawn for every sample, or printed once with noStream
+	4. with the json format, every sample is printed as a json array on a line of its own
+*/
+func statsContainers(containerNames []string, noStream bool, format string) error {
+	if format != "" && format != "json" {
+		return fmt.Errorf("unknown format %s, only json is supported", format)
+	}
+	infos, err := statsTargets(containerNames)
+	if err != nil {
+		return err
+	}
+	memTotal := hostMemory()
+	prev := sampleStats(infos)
+	for {
+		time.Sleep(statsInterval)
+		if len(containerNames) == 0 {
+			if infos, err = statsTargets(nil); err != nil {
+				return err
+			}
+		}
+		cur := sampleStats(infos)
+
+		var all []*containerStats
+		for _, info := range infos {
+			// a container with no sample exited, or started after the previous one
+			before, after := prev[info.Name], cur[info.Name]
+			if before == nil || after == nil {
+				continue
+			}
+			all = append(all, newContainerStats(info, before, after, memTotal))
+		}
+		prev = cur
+
+		if format == "json" {
+			content, err := json.Marshal(all)
+			if err != nil {
+				return fmt.Errorf("json marshal stats error %v", err)
+			}
+			fmt.Println(string(content))
+		} else {
+			if !noStream {
+				// clear the screen and move to its top left, to draw the table over the previous one
+				fmt.Print("\033[2J\033[H")
+			}
+			printStatsTable(all)
+		}
+		if noStream {
+			return nil
+		}
+	}
+}
+
+// statsTargets returns the infos of the named containers, which have to be running,
+// or of every running container if none is named
+func statsTargets(containerNames []string) ([]*container.Info, error) {
+	var infos []*container.Info
+	if len(containerNames) == 0 {
+		dirURL := strings.TrimSuffix(fmt.Sprintf(container.DefaultInfoLocation, ""), "/")
+		files, err := ioutil.ReadDir(dirURL)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("read dir %s error %v", dirURL, err)
+		}
+		for _, file := range files {
+			info, err := getContainerInfo(file)
+			if err != nil {
+				continue
+			}
+			if info.Status == container.RUNNING || info.Status == container.PAUSED {
+				infos = append(infos, info)
+			}
+		}
+		return infos, nil
+	}
+	for _, containerName := range containerNames {
+		info, err := getContainerInfoByName(containerName)
+		if err != nil {
+			return nil, err
+		}
+		if info.Status != container.RUNNING && info.Status != container.PAUSED {
+			return nil, fmt.Errorf("container %s is not running", containerName)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// sampleStats reads the usage of the cgroups of the containers, by name
+func sampleStats(infos []*container.Info) map[string]*statsSample {
+	samples := map[string]*statsSample{}
+	for _, info := range infos {
+		stats, err := containerCgroupManager(info).GetStats()
+		if err != nil {
+			// a container that exited meanwhile has its cgroup gone along with it, anything else is worth a warning
+			if pid, _ := strconv.Atoi(info.Pid); pid > 0 && syscall.Kill(pid, 0) == nil {
+				log.Warnf("get stats of container %s error %v", info.Name, err)
+			} else {
+				log.Debugf("get stats of container %s error %v", info.Name, err)
+			}
+			continue
+		}
+		samples[info.Name] = &statsSample{stats: stats, at: time.Now()}
+	}
+	return samples
+}
+
+// newContainerStats works out the usage of a container from two samples of its cgroup
+func newContainerStats(info *container.Info, before *statsSample, after *statsSample, memTotal uint64) *containerStats {
+	s := &containerStats{
+		Id:          info.Id,
+		Name:        info.Name,
+		MemoryUsage: after.stats.MemoryUsage,
+		MemoryLimit: after.stats.MemoryLimit,
+		Pids:        after.stats.Pids,
+	}
+	if blkio := after.stats.Blkio; blkio != nil {
+		s.BlockRead, s.BlockWrite = &blkio.Read, &blkio.Write
+	}
+	if elapsed := after.at.Sub(before.at); elapsed > 0 && after.stats.CPUUsage >= before.stats.CPUUsage {
+		s.CPUPercent = float64(after.stats.CPUUsage-before.stats.CPUUsage) / float64(elapsed.Nanoseconds()) * 100
+	}
+	if s.MemoryLimit == 0 || (memTotal != 0 && s.MemoryLimit > memTotal) {
+		s.MemoryLimit = memTotal
+	}
+	if s.MemoryLimit != 0 {
+		s.MemoryPercent = float64(s.MemoryUsage) / float64(s.MemoryLimit) * 100
+	}
+	return s
+}
+
+// printStatsTable prints the usage of the containers as a table
+func printStatsTable(all []*containerStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tPIDS\tPIDS PEAK\tBLOCK I/O\n")
+	for _, s := range all {
+		// what the kernel does not account for is shown as --
+		pids, peak, blockIO := "--", "--", "--"
+		if s.Pids != nil {
+			pids = strconv.FormatUint(s.Pids.Current, 10)
+			// pids.peak only came with linux 6.1
+			if s.Pids.Peak != 0 {
+				peak = strconv.FormatUint(s.Pids.Peak, 10)
+			}
+		}
+		if s.BlockRead != nil && s.BlockWrite != nil {
+			blockIO = humanSize(*s.BlockRead) + " / " + humanSize(*s.BlockWrite)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s\t%s\t%s\t\n",
+			s.Id,
+			s.Name,
+			s.CPUPercent,
+			humanSize(s.MemoryUsage),
+			humanSize(s.MemoryLimit),
+			s.MemoryPercent,
+			pids,
+			peak,
+			blockIO)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("flush error %v", err)
+	}
+}
+
+// humanSize prints a number of bytes with a binary unit, such as 1.5MiB
+func humanSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
+
+// hostMemory returns the memory of the host in bytes from /proc/meminfo, 0 if it cannot be read
+func hostMemory() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16318164 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
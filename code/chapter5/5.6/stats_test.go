@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+)
+
+func TestNewContainerStats(t *testing.T) {
+	info := &container.Info{Id: "0123456789", Name: "web"}
+	at := time.Now()
+	before := &statsSample{stats: &subsystems.Stats{CPUUsage: 1e9}, at: at}
+	after := &statsSample{
+		stats: &subsystems.Stats{
+			CPUUsage:    1.5e9,
+			MemoryUsage: 256 << 20,
+			Pids:        &subsystems.PidsStats{Current: 3, Peak: 5, Limit: 10},
+			Blkio:       &subsystems.BlkioStats{Read: 4096, Write: 512},
+		},
+		at: at.Add(time.Second),
+	}
+	s := newContainerStats(info, before, after, 1<<30)
+	if s.CPUPercent != 50 {
+		t.Errorf("CPU %% = %v, want 50", s.CPUPercent)
+	}
+	// without a limit, the memory of the host is the limit
+	if s.MemoryLimit != 1<<30 || s.MemoryPercent != 25 {
+		t.Errorf("memory limit %d, %v%%, want %d, 25%%", s.MemoryLimit, s.MemoryPercent, 1<<30)
+	}
+	if s.Pids == nil || s.Pids.Current != 3 || s.BlockRead == nil || *s.BlockRead != 4096 || s.BlockWrite == nil || *s.BlockWrite != 512 {
+		t.Errorf("pids %+v, block I/O %v / %v, want 3 pids and 4096 / 512", s.Pids, s.BlockRead, s.BlockWrite)
+	}
+
+	// what the kernel does not account for is left out, not made up
+	after.stats.Pids, after.stats.Blkio = nil, nil
+	s = newContainerStats(info, before, after, 1<<30)
+	if s.Pids != nil || s.BlockRead != nil || s.BlockWrite != nil {
+		t.Errorf("pids %+v, block I/O %v / %v, want none", s.Pids, s.BlockRead, s.BlockWrite)
+	}
+	content, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"pids", "blockRead", "blockWrite"} {
+		if strings.Contains(string(content), `"`+key+`"`) {
+			t.Errorf("json %s has %s", content, key)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := []struct {
+		size uint64
+		want string
+	}{
+		{0, "0B"},
+		{1023, "1023B"},
+		{1024, "1KiB"},
+		{1536, "1.5KiB"},
+		{256 << 20, "256MiB"},
+		{3 << 30, "3GiB"},
+		{5 << 40, "5TiB"},
+		{2048 << 40, "2048TiB"},
+	}
+	for _, test := range tests {
+		if got := humanSize(test.size); got != test.want {
+			t.Errorf("humanSize(%d) = %s, want %s", test.size, got, test.want)
+		}
+	}
+}
@@ -8,49 +8,180 @@ import (
 	"path"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
 	log "github.com/sirupsen/logrus"
 )
 
-func stopContainer(containerName string) {
-	// get the containrt's PID
-	pid, err := getContainerPIDByName(containerName)
+// some constants
+var (
+	// how often stop checks whether the container has exited
+	stopPollInterval = 100 * time.Millisecond
+	// how long stop waits for the container to exit after killing it
+	stopKillTimeout = 10 * time.Second
+)
+
+/*
+	stopContainer
+	1. sends the stop signal of the image, SIGTERM by default, to the init process of the container
+	2. waits up to timeout for the container to exit, then sends SIGKILL
+	3. marks the container as stopped once the run process or the shim waiting for it has recorded its exit
+*/
+func stopContainer(containerName string, timeout time.Duration) error {
+	containerInfo, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		// tell the shim not to restart the container, which it may be about to do even if the container is not running
+		containerInfo.ManuallyStopped = true
+		// a frozen process would not act on the stop signal, nor on SIGKILL
+		if containerInfo.Status == container.PAUSED {
+			return thawContainer(containerInfo)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Errorf("get container pid by name %s error %v", containerName, err)
-		return
+		return fmt.Errorf("stop container %s error %v", containerName, err)
+	}
+	if containerInfo.Status != container.RUNNING {
+		log.Infof("container %s is not running", containerName)
+		return nil
 	}
+	// get the containrt's PID
+	pid := containerInfo.Pid
 	// convert PID from string to int
 	intPid, err := strconv.Atoi(pid)
 	if err != nil {
-		log.Errorf("error converting pid %s from string to int %v", pid, err)
-		return
+		return fmt.Errorf("error converting pid %s from string to int %v", pid, err)
 	}
-	// use the kill syscall to send SIGTERM to the process
-	if err := syscall.Kill(intPid, syscall.SIGTERM); err != nil {
-		log.Errorf("stop container %s error %v", containerName, err)
-		return
+	stopSignal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if stopSignal, err = parseSignal(containerInfo.StopSignal); err != nil {
+			return fmt.Errorf("stop signal of container %s error %v", containerName, err)
+		}
 	}
-	log.Infof("sent kill command to container %s with pid %s", containerName, pid)
-	containerInfo, err := getContainerInfoByName(containerName)
+	// use the kill syscall to send the stop signal to the process
+	if err := syscall.Kill(intPid, stopSignal); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("stop container %s error %v", containerName, err)
+	}
+	log.Infof("sent %v to container %s with pid %s", stopSignal, containerName, pid)
+	if !waitContainerExit(containerName, pid, timeout) {
+		log.Infof("container %s did not exit in %v, killing it", containerName, timeout)
+		if err := syscall.Kill(intPid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return fmt.Errorf("kill container %s error %v", containerName, err)
+		}
+		if !waitContainerExit(containerName, pid, stopKillTimeout) {
+			return fmt.Errorf("container %s did not exit after being killed", containerName)
+		}
+	}
+
+	// the exit has been recorded, now we need to modify the container's status and set its PID to empty
+	_, err = modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		// the waiter of the container destroys its cgroup, unless it died with the container
+		containerCgroupManager(containerInfo).Destroy()
+		containerInfo.Status = container.STOP
+		containerInfo.Pid = " "
+		return nil
+	})
 	if err != nil {
-		log.Errorf("get container %s's info error %v", containerName, err)
-		return
+		// a container run with --rm is gone by now
+		if exist, _ := container.PathExists(fmt.Sprintf(container.DefaultInfoLocation, containerName)); !exist {
+			log.Infof("container %s has been removed", containerName)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// waitContainerExit waits up to timeout for the init process pid of the container to exit and for its exit to be recorded,
+// it returns false if the container is still running after timeout
+func waitContainerExit(containerName string, pid string, timeout time.Duration) bool {
+	intPid, _ := strconv.Atoi(pid)
+	// once the waiter of the container has reaped it, the exit is recorded in a moment
+	var goneSince time.Time
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(stopPollInterval) {
+		containerInfo, err := getContainerInfoByName(containerName)
+		// the pid is cleared once the exit is recorded
+		if err != nil || containerInfo.Pid != pid {
+			return true
+		}
+		if err := syscall.Kill(intPid, 0); err == syscall.ESRCH {
+			if goneSince.IsZero() {
+				goneSince = time.Now()
+			} else if time.Since(goneSince) > time.Second {
+				// there is nobody left to record the exit
+				return true
+			}
+		}
 	}
-	// now we need to modify the container's status and set its PID to empty
-	containerInfo.Status = container.STOP
-	containerInfo.Pid = " "
+	return false
+}
+
+// updateContainerInfo overwrites the config.json of the container with info
+// the new file is written next to the old one and renamed over it, so that readers never see it half written
+func updateContainerInfo(containerInfo *container.Info) error {
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
-		log.Errorf("json marchall container %s'info error %v", containerName, err)
-		return
+		return fmt.Errorf("json marchall container %s'info error %v", containerInfo.Name, err)
 	}
-	saveDirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	saveDirURL := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	saveFileName := path.Join(saveDirURL, container.ConfigName)
-	if err := ioutil.WriteFile(saveFileName, jsonBytes, 0622); err != nil {
-		log.Errorf("write to file %s error %v", saveFileName, err)
+	tmpFile, err := ioutil.TempFile(saveDirURL, container.ConfigName+".")
+	if err != nil {
+		return fmt.Errorf("create temp file in %s error %v", saveDirURL, err)
+	}
+	_, err = tmpFile.Write(jsonBytes)
+	if err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), saveFileName)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return fmt.Errorf("write to file %s error %v", saveFileName, err)
 	}
 	log.Infof("overwritten config file %s", saveFileName)
+	return nil
+}
+
+// modifyContainerInfo applies modify to the info of the container and records the result,
+// the info is locked meanwhile so that the changes of mydocker commands and the shim never overwrite each other
+// nothing is recorded if modify fails, modify must not lock the info again
+func modifyContainerInfo(containerName string, modify func(*container.Info) error) (*container.Info, error) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("get container %s's info error %v", containerName, err)
+	}
+	if err := modify(containerInfo); err != nil {
+		return nil, err
+	}
+	if err := updateContainerInfo(containerInfo); err != nil {
+		return nil, err
+	}
+	return containerInfo, nil
+}
+
+// lockContainerInfo takes an exclusive flock on the info dir of the container and returns the function releasing it
+func lockContainerInfo(containerName string) (func(), error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dir, err := os.Open(dirURL)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error %v", dirURL, err)
+	}
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("lock %s error %v", dirURL, err)
+	}
+	// closing the dir releases the lock
+	return func() { dir.Close() }, nil
 }
 
 func getContainerInfoByName(containerName string) (*container.Info, error) {
@@ -72,22 +203,31 @@ func getContainerInfoByName(containerName string) (*container.Info, error) {
 	return &containerInfo, nil
 }
 
-func removeContainer(containerName string, volume string) {
+func removeContainer(containerName string) {
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
 		log.Errorf("get container %s's info error %v", containerName, err)
 		return
 	}
-	// only remove stopped containers
-	if containerInfo.Status != container.STOP {
+	if containerInfo.Status == container.CREATED {
+		// the init process of a created container only waits for its command, the shim cleans up after it
+		if pid, err := strconv.Atoi(containerInfo.Pid); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+			waitContainerExit(containerName, containerInfo.Pid, stopKillTimeout)
+		}
+		if containerInfo, err = getContainerInfoByName(containerName); err != nil {
+			// a container created with --rm is gone by now
+			return
+		}
+	}
+	// only remove created, stopped or exited containers
+	if containerInfo.Status != container.CREATED && containerInfo.Status != container.STOP && containerInfo.Status != container.EXIT {
 		log.Errorf("can't remove a running container!")
 		return
 	}
+	// the waiter of the container destroys its cgroup, unless it died with the container
+	containerCgroupManager(containerInfo).Destroy()
+	// the workspace and volume to clean up are the ones recorded for this container
+	container.DeleteWorkSpace(containerInfo)
 	deleteContainerInfo(containerName)
-
-	mntURL := "/root/mnt/"
-	rootURL := "/root/"
-	if _, err := os.Stat(mntURL); !os.IsNotExist(err) {
-		container.DeleteWorkSpace(rootURL, mntURL, volume)
-	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+)
+
+func TestModifyContainerInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(location string) { container.DefaultInfoLocation = location }(container.DefaultInfoLocation)
+	container.DefaultInfoLocation = path.Join(dir, "%s") + "/"
+	if err := os.MkdirAll(path.Join(dir, "test"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateContainerInfo(&container.Info{Name: "test", Status: container.RUNNING}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every change is kept, however many are made at once
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := modifyContainerInfo("test", func(containerInfo *container.Info) error {
+				containerInfo.ExitCode++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// a failed change is not recorded
+	_, err = modifyContainerInfo("test", func(containerInfo *container.Info) error {
+		containerInfo.Status = container.STOP
+		return fmt.Errorf("refused")
+	})
+	if err == nil {
+		t.Errorf("modifyContainerInfo with a failing change succeeded")
+	}
+
+	containerInfo, err := getContainerInfoByName("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containerInfo.ExitCode != 20 || containerInfo.Status != container.RUNNING {
+		t.Errorf("got exit code %d and status %s, want 20 and %s", containerInfo.ExitCode, containerInfo.Status, container.RUNNING)
+	}
+	// only config.json is left, readable by everyone
+	files, err := ioutil.ReadDir(path.Join(dir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != container.ConfigName || files[0].Mode().Perm() != 0644 {
+		t.Errorf("info dir holds %d files, first %s with mode %v", len(files), files[0].Name(), files[0].Mode())
+	}
+}
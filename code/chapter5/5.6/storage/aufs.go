@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// AufsDriver struct
+type AufsDriver struct {
+}
+
+// Mount stacks the layers with "mount -t aufs", the first branch is writable and the rest read-only
+func (d *AufsDriver) Mount(lowerURLs []string, writeURL string, workURL string, mntURL string) error {
+	dirs := "dirs=" + strings.Join(append([]string{writeURL}, lowerURLs...), ":")
+	return d.mount(dirs, mntURL)
+}
+
+// MountVolume mounts hostURL onto containerURL as a single branch aufs
+func (d *AufsDriver) MountVolume(hostURL string, containerURL string) error {
+	return d.mount("dirs="+hostURL, containerURL)
+}
+
+// Unmount runs "umount mntURL"
+func (d *AufsDriver) Unmount(mntURL string) error {
+	cmd := exec.Command("umount", mntURL)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("umount %s error %v", mntURL, err)
+	}
+	log.Infof("\"umount %s\" successful", mntURL)
+	return nil
+}
+
+// ImportWhiteouts does nothing, aufs uses the same .wh. files as OCI layers
+func (d *AufsDriver) ImportWhiteouts(layerURL string) error {
+	return nil
+}
+
+// IsWhiteout is always false, the .wh. files of aufs are already OCI whiteouts
+func (d *AufsDriver) IsWhiteout(fileURL string, info os.FileInfo) bool {
+	return false
+}
+
+// IsOpaque is always false, aufs marks opaque dirs with a .wh..wh..opq file
+func (d *AufsDriver) IsOpaque(dirURL string) bool {
+	return false
+}
+
+// Name returns driver's name
+func (d *AufsDriver) Name() string {
+	return "aufs"
+}
+
+func (d *AufsDriver) mount(dirs string, mntURL string) error {
+	cmd := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mount -t aufs -o %s none %s error %v", dirs, mntURL, err)
+	}
+	log.Infof("\"mount -t aufs -o %s none %s\" successful", dirs, mntURL)
+	return nil
+}
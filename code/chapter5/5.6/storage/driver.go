@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Driver interfaces
+// a storage driver stacks the read-only image layers and the container's
+// write layer into a single root filesystem for the container
+type Driver interface {
+	// returns name of the driver, which is also the name of the fs in /proc/filesystems
+	Name() string
+
+	// mounts lowerURLs (top-most layer first) read-only and writeURL read-write on mntURL
+	// workURL is a scratch dir on the same fs as writeURL, for drivers that need one
+	Mount(lowerURLs []string, writeURL string, workURL string, mntURL string) error
+
+	// mounts the host directory hostURL on containerURL inside the container
+	MountVolume(hostURL string, containerURL string) error
+
+	// unmounts whatever the driver mounted on mntURL
+	Unmount(mntURL string) error
+
+	// converts the OCI whiteouts (.wh.<name> and .wh..wh..opq) of the unpacked layer at layerURL into the driver's own form
+	ImportWhiteouts(layerURL string) error
+
+	// tells if the file at fileURL of a write layer is the driver's whiteout for a deleted file
+	IsWhiteout(fileURL string, info os.FileInfo) bool
+
+	// tells if the directory at dirURL of a write layer hides the contents of the layers below it
+	IsOpaque(dirURL string) bool
+}
+
+// some constants
+var (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = ".wh..wh..opq"
+)
+
+// use different drivers to initialize an array of storage driver instances
+// the order is the order of preference when detecting the driver to use
+var (
+	DriversIns = []Driver{
+		&OverlayDriver{},
+		&AufsDriver{},
+	}
+)
+
+// GetDriver returns the storage driver called name
+// an empty name picks the first driver supported by the kernel
+func GetDriver(name string) (Driver, error) {
+	if name == "" {
+		return DetectDriver()
+	}
+	for _, driver := range DriversIns {
+		if driver.Name() == name {
+			return driver, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown storage driver %s", name)
+}
+
+// DetectDriver uses /proc/filesystems to find the first driver the kernel supports
+func DetectDriver() (Driver, error) {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return nil, fmt.Errorf("open /proc/filesystems error %v", err)
+	}
+	defer f.Close()
+
+	// every line looks like "nodev	overlay" or "	ext4"
+	supported := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			supported[fields[len(fields)-1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read /proc/filesystems error %v", err)
+	}
+
+	for _, driver := range DriversIns {
+		if supported[driver.Name()] {
+			return driver, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the storage drivers is supported by the kernel")
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// overlayOpaqueXattr marks a directory of an overlay layer as opaque
+const overlayOpaqueXattr = "trusted.overlay.opaque"
+
+// OverlayDriver struct
+type OverlayDriver struct {
+}
+
+// Mount stacks the layers with an overlay mount, writeURL becomes the upperdir
+func (d *OverlayDriver) Mount(lowerURLs []string, writeURL string, workURL string, mntURL string) error {
+	// overlay needs an empty workdir on the same fs as the upperdir
+	if err := os.MkdirAll(workURL, 0700); err != nil {
+		return fmt.Errorf("mkdir dir %s error %v", workURL, err)
+	}
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowerURLs, ":"), writeURL, workURL)
+	if err := syscall.Mount("overlay", mntURL, "overlay", 0, options); err != nil {
+		return fmt.Errorf("mount overlay on %s with %s error %v", mntURL, options, err)
+	}
+	log.Infof("mounted overlay on %s with %s", mntURL, options)
+	return nil
+}
+
+// MountVolume bind mounts hostURL onto containerURL
+func (d *OverlayDriver) MountVolume(hostURL string, containerURL string) error {
+	if err := syscall.Mount(hostURL, containerURL, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mount %s on %s error %v", hostURL, containerURL, err)
+	}
+	log.Infof("bind mounted %s on %s", hostURL, containerURL)
+	return nil
+}
+
+// Unmount unmounts mntURL
+func (d *OverlayDriver) Unmount(mntURL string) error {
+	if err := syscall.Unmount(mntURL, 0); err != nil {
+		return fmt.Errorf("umount %s error %v", mntURL, err)
+	}
+	log.Infof("unmounted %s", mntURL)
+	return nil
+}
+
+// ImportWhiteouts turns .wh.<name> files into 0:0 char devices called <name>
+// and .wh..wh..opq files into the trusted.overlay.opaque xattr of their dir
+func (d *OverlayDriver) ImportWhiteouts(layerURL string) error {
+	// collect the whiteouts first, so that the walk does not see the files it creates
+	var whiteouts []string
+	err := filepath.Walk(layerURL, func(fileURL string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// the aufs housekeeping files (.wh..wh.aufs, .wh..wh.plnk, ...) of layers made by aufs are no whiteouts
+		if strings.HasPrefix(info.Name(), WhiteoutPrefix+WhiteoutPrefix) && info.Name() != WhiteoutOpaque {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), WhiteoutPrefix) {
+			whiteouts = append(whiteouts, fileURL)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk layer %s error %v", layerURL, err)
+	}
+
+	for _, whiteoutURL := range whiteouts {
+		dirURL, name := filepath.Split(whiteoutURL)
+		if err := os.Remove(whiteoutURL); err != nil {
+			return fmt.Errorf("remove whiteout %s error %v", whiteoutURL, err)
+		}
+		if name == WhiteoutOpaque {
+			if err := syscall.Setxattr(dirURL, overlayOpaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque xattr on %s error %v", dirURL, err)
+			}
+			continue
+		}
+		deletedURL := filepath.Join(dirURL, strings.TrimPrefix(name, WhiteoutPrefix))
+		if err := syscall.Mknod(deletedURL, syscall.S_IFCHR, 0); err != nil {
+			return fmt.Errorf("mknod whiteout %s error %v", deletedURL, err)
+		}
+	}
+	log.Infof("converted %d whiteouts of %s to overlay whiteouts", len(whiteouts), layerURL)
+	return nil
+}
+
+// IsWhiteout tells if fileURL is a 0:0 char device
+func (d *OverlayDriver) IsWhiteout(fileURL string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// IsOpaque tells if dirURL has the trusted.overlay.opaque xattr set
+func (d *OverlayDriver) IsOpaque(dirURL string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dirURL, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// Name returns driver's name
+func (d *OverlayDriver) Name() string {
+	return "overlay"
+}
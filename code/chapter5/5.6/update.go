@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/cgroups/subsystems"
+	"github.com/haiyang1992/mydocker/code/chapter5/5.6/container"
+	"github.com/urfave/cli"
+)
+
+/*
+	updateContainer
+	This changes the resource limits of a container given in the flags of update
+	1. the new limits are the recorded ones with the flags applied, checked as a whole like those of run
+	2. a container with a cgroup gets them right away, unless they would take away memory it is using
+	3. if the cgroup refuses them, the limits it had are restored, so that the container is never left half updated
+	4. the new limits are recorded in config.json, a stopped container gets them when it is started again
+*/
+func updateContainer(containerName string, context *cli.Context) error {
+	// the info stays locked until the new limits are recorded, so that the shim records its changes after them
+	// and two updates never mix their limits
+	_, err := modifyContainerInfo(containerName, func(containerInfo *container.Info) error {
+		oldRes := containerInfo.Resources
+		if oldRes == nil {
+			oldRes = &subsystems.ResourceConfig{}
+		}
+		res := *oldRes
+		if err := parseResources(context, &res); err != nil {
+			return err
+		}
+
+		switch containerInfo.Status {
+		case container.CREATED, container.RUNNING, container.PAUSED, container.RESTARTING:
+			cgroupManager := containerCgroupManager(containerInfo)
+			if res.MemoryLimit != oldRes.MemoryLimit {
+				if err := checkMemoryUsage(containerName, cgroupManager, res.MemoryLimit); err != nil {
+					return err
+				}
+			}
+			if err := cgroupManager.Update(&res); err != nil {
+				return fmt.Errorf("update container %s error %v", containerName, err)
+			}
+		}
+		containerInfo.Resources = &res
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(containerName)
+	return nil
+}
+
+// checkMemoryUsage refuses a memory limit below what the container uses, the kernel could only get there by reclaiming or killing
+func checkMemoryUsage(containerName string, cgroupManager *cgroups.CgroupManager, memoryLimit string) error {
+	limit, err := subsystems.ParseMemory(memoryLimit)
+	if err != nil {
+		return fmt.Errorf("invalid memory limit %s", memoryLimit)
+	}
+	usage, err := cgroupManager.GetMemoryUsage()
+	if err != nil {
+		return fmt.Errorf("get memory usage of container %s error %v", containerName, err)
+	}
+	if uint64(limit) < usage {
+		return fmt.Errorf("memory limit %s is below the %d bytes container %s is using", memoryLimit, usage, containerName)
+	}
+	return nil
+}